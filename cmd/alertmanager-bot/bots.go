@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/metalmatze/alertmanager-bot/pkg/alertmanager"
+	botconfig "github.com/metalmatze/alertmanager-bot/pkg/config"
+)
+
+// botsFlag parses repeated flags for additional bots like
+// name=payments,token=123:abc,admins=1;2,templates=/templates/payments.tmpl,group_admins=true
+type botsFlag []botconfig.Bot
+
+func (f *botsFlag) Set(value string) error {
+	var c botconfig.Bot
+	for _, field := range strings.Split(value, ",") {
+		kv := strings.SplitN(strings.TrimSpace(field), "=", 2)
+		if len(kv) != 2 {
+			return fmt.Errorf("expected key=value, got %q", field)
+		}
+
+		switch kv[0] {
+		case "name":
+			c.Name = kv[1]
+		case "token":
+			c.Token = kv[1]
+		case "admins":
+			for _, a := range strings.Split(kv[1], ";") {
+				id, err := strconv.Atoi(a)
+				if err != nil {
+					return fmt.Errorf("invalid admin ID %q", a)
+				}
+				c.Admins = append(c.Admins, id)
+			}
+		case "templates":
+			c.Templates = strings.Split(kv[1], ";")
+		case "group_admins":
+			groupAdmins, err := strconv.ParseBool(kv[1])
+			if err != nil {
+				return fmt.Errorf("invalid group_admins %q", kv[1])
+			}
+			c.GroupAdmins = groupAdmins
+		default:
+			return fmt.Errorf("unknown key %q", kv[0])
+		}
+	}
+
+	if !alertmanager.RouteNameRegexp.MatchString(c.Name) {
+		return fmt.Errorf("bot needs a name consisting of letters, digits, _ and -")
+	}
+	if c.Token == "" {
+		return fmt.Errorf("bot %s needs a token", c.Name)
+	}
+	if len(c.Admins) == 0 {
+		return fmt.Errorf("bot %s needs at least one admin", c.Name)
+	}
+	for _, b := range *f {
+		if b.Name == c.Name {
+			return fmt.Errorf("bot %s is configured more than once", c.Name)
+		}
+	}
+
+	*f = append(*f, c)
+	return nil
+}
+
+func (f *botsFlag) String() string {
+	names := make([]string, 0, len(*f))
+	for _, c := range *f {
+		names = append(names, c.Name)
+	}
+	return strings.Join(names, ",")
+}
+
+// IsCumulative allows the flag to be given multiple times
+func (f *botsFlag) IsCumulative() bool {
+	return true
+}
@@ -9,6 +9,8 @@ import (
 	"os/signal"
 	"runtime"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/docker/libkv/store"
@@ -19,9 +21,9 @@ import (
 	"github.com/hako/durafmt"
 	"github.com/joho/godotenv"
 	"github.com/metalmatze/alertmanager-bot/pkg/alertmanager"
+	botconfig "github.com/metalmatze/alertmanager-bot/pkg/config"
 	"github.com/metalmatze/alertmanager-bot/pkg/telegram"
 	"github.com/oklog/run"
-	"github.com/prometheus/alertmanager/notify"
 	"github.com/prometheus/alertmanager/template"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
@@ -32,6 +34,9 @@ const (
 	storeBolt   = "bolt"
 	storeConsul = "consul"
 
+	telegramModePolling = "polling"
+	telegramModeWebhook = "webhook"
+
 	levelDebug = "debug"
 	levelInfo  = "info"
 	levelWarn  = "warn"
@@ -64,18 +69,36 @@ func main() {
 		store          			string
 		telegramAdmins 			[]int
 		telegramToken  			string
+		telegramGroupAdmins		bool
+		telegramMode			string
+		telegramWebhookURL		*url.URL
+		telegramWebhookToken	string
+		telegramWebhookCert		string
+		haEnabled				bool
+		haID					string
+		haLockTTL				time.Duration
 		templatesPaths 			[]string
-		prometheusEnvironments 	string
-		prometheusProjects 		string
+		templatesReloadInterval	time.Duration
 		fetchMessagesPeriod		float64
 		deleteMessagesPeriod	float64
+		webhookAuth				alertmanager.WebhookAuth
+		bots					botsFlag
+		configFile				string
+		enableLifecycle			bool
 	}{}
 
 	a := kingpin.New("alertmanager-bot", "Bot for Prometheus' Alertmanager")
 	a.HelpFlag.Short('h')
 
+	a.Flag("web.enable-lifecycle", "Enable reloading the configuration with POST /-/reload, authenticated like webhooks").
+		Envar("WEB_ENABLE_LIFECYCLE").
+		BoolVar(&config.enableLifecycle)
+
+	a.Flag("config.file", "The YAML file to read the configuration from instead of the flags, reloaded on SIGHUP or POST /-/reload").
+		Envar("CONFIG_FILE").
+		ExistingFileVar(&config.configFile)
+
 	a.Flag("alertmanager.url", "The URL that's used to connect to the alertmanager").
-		Required().
 		Envar("ALERTMANAGER_URL").
 		URLVar(&config.alertmanager)
 
@@ -102,34 +125,67 @@ func main() {
 		EnumVar(&config.logLevel, levelError, levelWarn, levelInfo, levelDebug)
 
 	a.Flag("store", "The store to use").
-		Required().
 		Envar("STORE").
 		EnumVar(&config.store, storeBolt, storeConsul)
 
 	a.Flag("telegram.admin", "The ID of the initial Telegram Admin").
-		Required().
 		Envar("TELEGRAM_ADMIN").
 		IntsVar(&config.telegramAdmins)
 
 	a.Flag("telegram.token", "The token used to connect with Telegram").
-		Required().
 		Envar("TELEGRAM_TOKEN").
 		StringVar(&config.telegramToken)
 
+	a.Flag("telegram.group-admins", "Let the administrators of a group manage its subscription and mutes").
+		Envar("TELEGRAM_GROUP_ADMINS").
+		BoolVar(&config.telegramGroupAdmins)
+
+	a.Flag("telegram.mode", "How updates are received from Telegram, by long polling or by a webhook Telegram pushes them to").
+		Envar("TELEGRAM_MODE").
+		Default(telegramModePolling).
+		EnumVar(&config.telegramMode, telegramModePolling, telegramModeWebhook)
+
+	a.Flag("telegram.webhook.url", "The public HTTPS URL Telegram reaches the listen address at, each bot's path is appended to it").
+		Envar("TELEGRAM_WEBHOOK_URL").
+		URLVar(&config.telegramWebhookURL)
+
+	a.Flag("telegram.webhook.secret-token", "The secret token Telegram has to send with every update in the "+telegram.SecretTokenHeader+" header").
+		Envar("TELEGRAM_WEBHOOK_SECRET_TOKEN").
+		StringVar(&config.telegramWebhookToken)
+
+	a.Flag("telegram.webhook.certificate", "The public key certificate uploaded to Telegram when the webhook uses a self-signed certificate").
+		Envar("TELEGRAM_WEBHOOK_CERTIFICATE").
+		ExistingFileVar(&config.telegramWebhookCert)
+
+	a.Flag("bot", "An additional bot to run, e.g. name=payments,token=123:abc,admins=1;2,templates=/templates/payments.tmpl").
+		Envar("BOTS").
+		SetValue(&config.bots)
+
+	hostname, _ := os.Hostname()
+
+	a.Flag("ha.enabled", "Run as one of several replicas sharing the consul store, only the elected leader talks to Telegram").
+		Envar("HA_ENABLED").
+		BoolVar(&config.haEnabled)
+
+	a.Flag("ha.id", "The ID this replica holds the leader lock with").
+		Envar("HA_ID").
+		Default(hostname).
+		StringVar(&config.haID)
+
+	a.Flag("ha.lock-ttl", "How long the leader lock is kept once its replica is gone").
+		Envar("HA_LOCK_TTL").
+		Default("15s").
+		DurationVar(&config.haLockTTL)
+
 	a.Flag("template.paths", "The paths to the template").
 		Envar("TEMPLATE_PATHS").
 		Default("/templates/default.tmpl").
 		ExistingFilesVar(&config.templatesPaths)
 
-	a.Flag("prometheus.environments", "Environments defined in Prometheus").
-		Required().
-		Envar("PROMETHEUS_ENVS").
-		StringVar(&config.prometheusEnvironments)
-
-	a.Flag("prometheus.projects", "Projects defined in Prometheus").
-		Required().
-		Envar("PROMETHEUS_PROJECTS").
-		StringVar(&config.prometheusProjects)
+	a.Flag("template.reload-interval", "How often the templates are checked for changes and reloaded, 0 disables it").
+		Envar("TEMPLATE_RELOAD_INTERVAL").
+		Default("30s").
+		DurationVar(&config.templatesReloadInterval)
 
 	a.Flag("fetch.period", "Scheduler period for fetching messages from store (in minutes)").
 		Required().
@@ -141,12 +197,81 @@ func main() {
 		Envar("DELETE_PERIOD").
 		Float64Var(&config.deleteMessagesPeriod)
 
+	a.Flag("webhook.bearer-token", "The bearer token webhooks have to be authorized with").
+		Envar("WEBHOOK_BEARER_TOKEN").
+		StringVar(&config.webhookAuth.BearerToken)
+
+	a.Flag("webhook.basic-auth.username", "The username webhooks have to be authorized with via basic auth").
+		Envar("WEBHOOK_BASIC_AUTH_USERNAME").
+		StringVar(&config.webhookAuth.Username)
+
+	a.Flag("webhook.basic-auth.password", "The password webhooks have to be authorized with via basic auth").
+		Envar("WEBHOOK_BASIC_AUTH_PASSWORD").
+		StringVar(&config.webhookAuth.Password)
+
+	a.Flag("webhook.hmac-secret", "The secret webhook bodies have to be signed with in the "+alertmanager.SignatureHeader+" header").
+		Envar("WEBHOOK_HMAC_SECRET").
+		StringVar(&config.webhookAuth.HMACSecret)
+
 	_, err := a.Parse(os.Args[1:])
 	if err != nil {
 		fmt.Printf("error parsing commandline arguments: %v\n", err)
 		a.Usage(os.Args[1:])
 		os.Exit(2)
 	}
+	if err := config.webhookAuth.Validate(); err != nil {
+		fmt.Printf("error parsing commandline arguments: %v\n", err)
+		a.Usage(os.Args[1:])
+		os.Exit(2)
+	}
+	if config.telegramMode == telegramModeWebhook && config.telegramWebhookURL == nil {
+		fmt.Println("error parsing commandline arguments: --telegram.webhook.url is required for --telegram.mode=webhook")
+		a.Usage(os.Args[1:])
+		os.Exit(2)
+	}
+
+	// loadConfig reads the config file if given, otherwise the config is built from the flags
+	loadConfig := func() (*botconfig.Config, error) {
+		if config.configFile != "" {
+			return botconfig.LoadFile(config.configFile)
+		}
+
+		c := &botconfig.Config{
+			Store: botconfig.Store{
+				Type:     strings.ToLower(config.store),
+				BoltPath: config.boltPath,
+			},
+			Templates: config.templatesPaths,
+		}
+		if config.alertmanager != nil {
+			c.AlertmanagerURL = config.alertmanager.String()
+		}
+		if config.consul != nil {
+			c.Store.ConsulURL = config.consul.String()
+		}
+		if config.telegramToken != "" {
+			c.Bots = append(c.Bots, botconfig.Bot{
+				Token:       config.telegramToken,
+				Admins:      config.telegramAdmins,
+				GroupAdmins: config.telegramGroupAdmins,
+			})
+		}
+		c.Bots = append(c.Bots, config.bots...)
+
+		return c, c.Validate()
+	}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		fmt.Printf("error loading configuration: %v\n", err)
+		a.Usage(os.Args[1:])
+		os.Exit(2)
+	}
+	if config.haEnabled && cfg.Store.Type != storeConsul {
+		fmt.Println("error loading configuration: --ha.enabled requires the consul store shared by all replicas")
+		a.Usage(os.Args[1:])
+		os.Exit(2)
+	}
 
 	levelFilter := map[string]level.Option{
 		levelError: level.AllowError(),
@@ -166,7 +291,6 @@ func main() {
 		"caller", log.DefaultCaller,
 	)
 
-	var tmpl *template.Template
 	{
 		funcs := template.DefaultFuncs
 		funcs["since"] = func(t time.Time) string {
@@ -177,26 +301,19 @@ func main() {
 		}
 
 		template.DefaultFuncs = funcs
-
-		tmpl, err = template.FromGlobs(config.templatesPaths...)
-		if err != nil {
-			level.Error(logger).Log("msg", "failed to parse templates", "err", err)
-			os.Exit(1)
-		}
-		tmpl.ExternalURL = config.alertmanager
 	}
 
 	var kvStore store.Store
 	{
-		switch strings.ToLower(config.store) {
+		switch cfg.Store.Type {
 		case storeBolt:
-			kvStore, err = boltdb.New([]string{config.boltPath}, &store.Config{Bucket: "alertmanager"})
+			kvStore, err = boltdb.New([]string{cfg.Store.BoltPath}, &store.Config{Bucket: "alertmanager"})
 			if err != nil {
 				level.Error(logger).Log("msg", "failed to create bolt store backend", "err", err)
 				os.Exit(1)
 			}
 		case storeConsul:
-			kvStore, err = consul.New([]string{config.consul.String()}, nil)
+			kvStore, err = consul.New([]string{cfg.Store.ConsulURL}, nil)
 			if err != nil {
 				level.Error(logger).Log("msg", "failed to create consul store backend", "err", err)
 				os.Exit(1)
@@ -210,37 +327,91 @@ func main() {
 
 	ctx, cancel := context.WithCancel(context.Background())
 
-	// TODO Needs fan out for multiple bots
-	webhooks := make(chan notify.WebhookMessage, 32)
+	// Webhooks are persisted in the store for every bot until all their messages were sent
+	webhooks := alertmanager.Dispatcher{}
+
+	// runningBot keeps what's needed to reload a bot's config
+	type runningBot struct {
+		bot    *telegram.Bot
+		chats  *telegram.ChatStore
+		config botconfig.Bot
+	}
+	running := make(map[string]runningBot)
 
 	var g run.Group
-	{
+	for _, bc := range cfg.Bots {
 		tlogger := log.With(logger, "component", "telegram")
+		registerer := prometheus.DefaultRegisterer
+		if len(cfg.Bots) > 1 {
+			name := bc.Name
+			if name == "" {
+				name = "default"
+			}
+			tlogger = log.With(tlogger, "bot", name)
+			registerer = prometheus.WrapRegistererWith(prometheus.Labels{"bot": name}, registerer)
+		}
 
-		chats, err := telegram.NewChatStore(kvStore)
+		amURL, err := cfg.BotAlertmanagerURL(bc)
+		if err != nil {
+			level.Error(tlogger).Log("msg", "invalid alertmanager url", "err", err)
+			os.Exit(1)
+		}
+
+		tmpl, err := parseTemplates(cfg.BotTemplates(bc), amURL)
+		if err != nil {
+			level.Error(tlogger).Log("msg", "failed to parse templates", "err", err)
+			os.Exit(1)
+		}
+
+		chats, err := telegram.NewChatStore(kvStore, telegram.WithNamespace(bc.Namespace()))
 		if err != nil {
 			level.Error(logger).Log("msg", "failed to create chat store", "err", err)
 			os.Exit(1)
 		}
+		if _, err := applyRoutes(chats, nil, bc.Routes); err != nil {
+			level.Error(tlogger).Log("msg", "failed to save routes", "err", err)
+			os.Exit(1)
+		}
 
-		bot, err := telegram.NewBot(
-			chats, config.telegramToken, config.telegramAdmins[0],
+		var queueOpts []alertmanager.WebhookQueueOption
+		if config.haEnabled {
+			queueOpts = append(queueOpts, alertmanager.WithReplica(config.haID))
+		}
+		queue := alertmanager.NewWebhookQueue(kvStore, bc.Namespace(), chats, queueOpts...)
+		webhooks[bc.Name] = queue
+
+		opts := []telegram.BotOption{
 			telegram.WithLogger(tlogger),
+			telegram.WithRegisterer(registerer),
 			telegram.WithAddr(config.listenAddr),
-			telegram.WithAlertmanager(config.alertmanager),
+			telegram.WithAlertmanager(amURL),
 			telegram.WithTemplates(tmpl),
+			telegram.WithTemplatePaths(cfg.BotTemplates(bc)...),
+			telegram.WithTemplateReloadInterval(config.templatesReloadInterval),
 			telegram.WithRevision(Revision),
 			telegram.WithStartTime(StartTime),
-			telegram.WithExtraAdmins(config.telegramAdmins[1:]...),
-			telegram.WithEnvironments(config.prometheusEnvironments),
-			telegram.WithProjects(config.prometheusProjects),
+			telegram.WithExtraAdmins(bc.Admins[1:]...),
+			telegram.WithGroupAdmins(bc.GroupAdmins),
 			telegram.WithFetchPeriod(config.fetchMessagesPeriod),
 			telegram.WithDeletePeriod(config.deleteMessagesPeriod),
-		)
+		}
+		if config.telegramMode == telegramModeWebhook {
+			opts = append(opts, telegram.WithUpdatesWebhook(
+				telegramWebhookURL(config.telegramWebhookURL, bc.Name),
+				config.telegramWebhookToken,
+				config.telegramWebhookCert,
+			))
+		}
+		if config.haEnabled {
+			opts = append(opts, telegram.WithLeaderElection(config.haID, config.haLockTTL))
+		}
+
+		bot, err := telegram.NewBot(chats, bc.Token, bc.Admins[0], opts...)
 		if err != nil {
 			level.Error(tlogger).Log("msg", "failed to create bot", "err", err)
 			os.Exit(2)
 		}
+		running[bc.Name] = runningBot{bot: bot, chats: chats, config: bc}
 
 		g.Add(func() error {
 			level.Info(tlogger).Log(
@@ -252,11 +423,122 @@ func main() {
 			)
 
 			// Runs the bot itself communicating with Telegram
-			return bot.Run(ctx, webhooks)
+			return bot.Run(ctx, queue)
 		}, func(err error) {
 			cancel()
 		})
+
+		if config.haEnabled {
+			// The leader has to learn about the webhooks its followers queued
+			g.Add(func() error {
+				return queue.Watch(ctx)
+			}, func(err error) {
+				cancel()
+			})
+		}
 	}
+
+	reloadSuccess := prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "alertmanagerbot",
+		Name:      "config_last_reload_successful",
+		Help:      "Whether the last configuration reload attempt was successful",
+	})
+	reloadSuccess.Set(1)
+	prometheus.MustRegister(reloadSuccess)
+
+	// reload validates the config, parses all templates and checks the routes before applying any of it to the running bots.
+	// The routes of all bots are rolled back if saving any of them fails, the bots are only reloaded afterwards.
+	// Bots keep running while reloading, changes to the store or the bots' tokens require a restart.
+	var reloadMu sync.Mutex
+	reload := func() (err error) {
+		reloadMu.Lock()
+		defer reloadMu.Unlock()
+		defer func() {
+			if err != nil {
+				reloadSuccess.Set(0)
+				level.Error(logger).Log("msg", "failed to reload config", "err", err)
+				return
+			}
+			reloadSuccess.Set(1)
+			level.Info(logger).Log("msg", "reloaded config")
+		}()
+
+		newCfg, err := loadConfig()
+		if err != nil {
+			return err
+		}
+		if newCfg.Store != cfg.Store {
+			level.Warn(logger).Log("msg", "changing the store requires a restart")
+		}
+
+		type update struct {
+			running runningBot
+			config  botconfig.Bot
+			opts    []telegram.BotOption
+		}
+		var updates []update
+		configured := make(map[string]bool)
+		for _, bc := range newCfg.Bots {
+			configured[bc.Name] = true
+			r, ok := running[bc.Name]
+			if !ok {
+				level.Warn(logger).Log("msg", "adding a bot requires a restart", "bot", bc.Name)
+				continue
+			}
+			if bc.Token != r.config.Token {
+				level.Warn(logger).Log("msg", "changing a bot's token requires a restart", "bot", bc.Name)
+			}
+
+			amURL, err := newCfg.BotAlertmanagerURL(bc)
+			if err != nil {
+				return err
+			}
+			tmpl, err := parseTemplates(newCfg.BotTemplates(bc), amURL)
+			if err != nil {
+				return fmt.Errorf("failed to parse templates of bot %q: %v", bc.Name, err)
+			}
+			if err := checkRoutes(logger, r.chats, bc.Routes); err != nil {
+				return fmt.Errorf("failed to check routes of bot %q: %v", bc.Name, err)
+			}
+
+			updates = append(updates, update{running: r, config: bc, opts: []telegram.BotOption{
+				telegram.WithExtraAdmins(bc.Admins[1:]...),
+				telegram.WithGroupAdmins(bc.GroupAdmins),
+				telegram.WithAlertmanager(amURL),
+				telegram.WithTemplates(tmpl),
+				telegram.WithTemplatePaths(newCfg.BotTemplates(bc)...),
+			}})
+		}
+
+		for name := range running {
+			if !configured[name] {
+				level.Warn(logger).Log("msg", "removing a bot requires a restart, it keeps running until then", "bot", name)
+			}
+		}
+
+		var rollbacks []func() error
+		for _, u := range updates {
+			rollback, err := applyRoutes(u.running.chats, u.running.config.Routes, u.config.Routes)
+			if err != nil {
+				for i := len(rollbacks) - 1; i >= 0; i-- {
+					if err := rollbacks[i](); err != nil {
+						level.Error(logger).Log("msg", "failed to roll back routes", "err", err)
+					}
+				}
+				return fmt.Errorf("failed to save routes of bot %q: %v", u.config.Name, err)
+			}
+			rollbacks = append(rollbacks, rollback)
+		}
+
+		for _, u := range updates {
+			u.running.bot.Reload(u.config.Admins[0], u.opts...)
+			u.running.config = u.config
+			running[u.config.Name] = u.running
+		}
+		cfg = newCfg
+		return nil
+	}
+
 	{
 		wlogger := log.With(logger, "component", "webserver")
 
@@ -271,10 +553,45 @@ func main() {
 			Help:      "Number of webhooks received by this bot",
 		})
 
-		prometheus.MustRegister(webhooksCounter)
+		webhooksRejectedCounter := prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "alertmanagerbot",
+			Name:      "webhooks_rejected_total",
+			Help:      "Number of webhooks rejected because they failed authentication",
+		})
+
+		prometheus.MustRegister(webhooksCounter, webhooksRejectedCounter)
+
+		if !config.webhookAuth.Enabled() {
+			level.Warn(wlogger).Log("msg", "webhooks are not authenticated, anyone reaching the listen address can send alerts")
+		}
+		if config.telegramMode == telegramModeWebhook && config.telegramWebhookToken == "" {
+			level.Warn(wlogger).Log("msg", "telegram updates are not authenticated, anyone reaching the listen address can send commands")
+		}
 
 		m := http.NewServeMux()
-		m.HandleFunc("/", alertmanager.HandleWebhook(wlogger, webhooksCounter, webhooks))
+		m.HandleFunc("/", alertmanager.AuthenticateWebhook(
+			wlogger, config.webhookAuth, webhooksRejectedCounter,
+			alertmanager.HandleWebhook(wlogger, webhooksCounter, webhooks),
+		))
+		if config.enableLifecycle {
+			m.HandleFunc("/-/reload", alertmanager.AuthenticateWebhook(
+				wlogger, config.webhookAuth, webhooksRejectedCounter,
+				func(w http.ResponseWriter, r *http.Request) {
+					if r.Method != http.MethodPost && r.Method != http.MethodPut {
+						w.WriteHeader(http.StatusMethodNotAllowed)
+						return
+					}
+					if err := reload(); err != nil {
+						http.Error(w, fmt.Sprintf("failed to reload config: %v", err), http.StatusInternalServerError)
+					}
+				},
+			))
+		}
+		for name, r := range running {
+			if h := r.bot.UpdatesHandler(); h != nil {
+				m.Handle(telegramWebhookPath(name), h)
+			}
+		}
 		m.Handle("/metrics", promhttp.Handler())
 		m.HandleFunc("/health", handleHealth)
 		m.HandleFunc("/healthz", handleHealth)
@@ -304,8 +621,115 @@ func main() {
 		})
 	}
 
+	{
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+
+		g.Add(func() error {
+			for {
+				select {
+				case <-hup:
+					reload()
+				case <-ctx.Done():
+					return nil
+				}
+			}
+		}, func(err error) {
+			cancel()
+		})
+	}
+
 	if err := g.Run(); err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
 }
+
+// parseTemplates parses the templates used to render messages for Telegram
+func parseTemplates(paths []string, externalURL *url.URL) (*template.Template, error) {
+	tmpl, err := template.FromGlobs(paths...)
+	if err != nil {
+		return nil, err
+	}
+	tmpl.ExternalURL = externalURL
+	return tmpl, nil
+}
+
+// telegramWebhookPath returns the path Telegram pushes the bot's updates to
+func telegramWebhookPath(name string) string {
+	if name == "" {
+		return "/telegram"
+	}
+	return "/telegram/" + name
+}
+
+// telegramWebhookURL returns the public URL Telegram pushes the bot's updates to
+func telegramWebhookURL(base *url.URL, name string) string {
+	return strings.TrimSuffix(base.String(), "/") + telegramWebhookPath(name)
+}
+
+// checkRoutes makes sure the routes' chats can be looked up in the store before they're saved.
+// Chats that didn't subscribe yet are only warned about, their webhooks are kept until they do.
+func checkRoutes(logger log.Logger, chats *telegram.ChatStore, routes map[string][]int64) error {
+	chatInfos, err := chats.List()
+	if err != nil {
+		return err
+	}
+	subscribed := make(map[int64]bool, len(chatInfos))
+	for _, chatInfo := range chatInfos {
+		subscribed[chatInfo.Chat.ID] = true
+	}
+
+	for name, chatIDs := range routes {
+		for _, id := range chatIDs {
+			if id == 0 {
+				return fmt.Errorf("route %q: 0 is not a chat ID", name)
+			}
+			if !subscribed[id] {
+				level.Warn(logger).Log("msg", "chat of route didn't subscribe with /start yet", "route", name, "chat_id", id)
+			}
+		}
+	}
+	return nil
+}
+
+// applyRoutes saves the routes configured for a bot in its store.
+// Routes that were configured before but aren't anymore are removed,
+// routes only added with /route_add are left alone.
+// If saving fails the routes are restored, otherwise the returned rollback restores them later.
+func applyRoutes(chats *telegram.ChatStore, previous, routes map[string][]int64) (func() error, error) {
+	names := make(map[string]bool)
+	for name := range previous {
+		names[name] = true
+	}
+	for name := range routes {
+		names[name] = true
+	}
+
+	saved := make(map[string][]int64, len(names))
+	for name := range names {
+		route, err := chats.GetRoute(name)
+		if err != nil {
+			return nil, err
+		}
+		saved[name] = route.ChatIDs
+	}
+	rollback := func() error {
+		for name, chatIDs := range saved {
+			if err := chats.SetRouteChats(name, chatIDs); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	for name := range names {
+		if err := chats.SetRouteChats(name, routes[name]); err != nil {
+			if rerr := rollback(); rerr != nil {
+				return nil, fmt.Errorf("%v, rolling back failed too: %v", err, rerr)
+			}
+			return nil, err
+		}
+	}
+	return rollback, nil
+}
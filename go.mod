@@ -34,6 +34,7 @@ require (
 	github.com/pkg/errors v0.9.1
 	github.com/prometheus/alertmanager v0.9.1
 	github.com/prometheus/client_golang v0.9.4
+	github.com/prometheus/common v0.4.1
 	github.com/prometheus/procfs v0.0.3 // indirect
 	github.com/robfig/cron/v3 v3.0.0
 	github.com/satori/go.uuid v1.1.0 // indirect
@@ -44,6 +45,7 @@ require (
 	gopkg.in/alecthomas/kingpin.v2 v2.2.6
 	gopkg.in/check.v1 v1.0.0-20180628173108-788fd7840127 // indirect
 	gopkg.in/tucnak/telebot.v2 v2.0.0-20200416071717-f096d2b1adbc
+	gopkg.in/yaml.v2 v2.2.2
 )
 
 go 1.13
@@ -3,29 +3,116 @@ package alertmanager
 import (
 	"encoding/json"
 	"net/http"
+	"time"
 
 	"github.com/go-kit/kit/log"
 	"github.com/prometheus/alertmanager/types"
+	"github.com/prometheus/common/model"
 )
 
-type alertResponse struct {
-	Status string         `json:"status"`
-	Alerts []*types.Alert `json:"data,omitempty"`
+// Possible states of an alert.
+const (
+	AlertStateUnprocessed = "unprocessed"
+	AlertStateActive      = "active"
+	AlertStateSuppressed  = "suppressed"
+)
+
+// Alert is an alert as returned by the Alertmanager.
+type Alert struct {
+	Labels       map[string]string `json:"labels"`
+	Annotations  map[string]string `json:"annotations"`
+	StartsAt     time.Time         `json:"startsAt"`
+	EndsAt       time.Time         `json:"endsAt"`
+	UpdatedAt    time.Time         `json:"updatedAt"`
+	GeneratorURL string            `json:"generatorURL"`
+	Fingerprint  string            `json:"fingerprint"`
+	Receivers    []Receiver        `json:"receivers"`
+	Status       AlertStatus       `json:"status"`
+}
+
+// AlertStatus tells if an alert is active or suppressed and by which silences or alerts.
+type AlertStatus struct {
+	State       string   `json:"state"`
+	SilencedBy  []string `json:"silencedBy"`
+	InhibitedBy []string `json:"inhibitedBy"`
+}
+
+// Receiver an alert is routed to.
+type Receiver struct {
+	Name string `json:"name"`
+}
+
+// AlertGroup is a group of alerts routed to the same receiver with the same group labels.
+type AlertGroup struct {
+	Labels   map[string]string `json:"labels"`
+	Receiver Receiver          `json:"receiver"`
+	Alerts   []Alert           `json:"alerts"`
+}
+
+// Silenced returns if the alert is silenced by at least one silence.
+func (a Alert) Silenced() bool {
+	return len(a.Status.SilencedBy) > 0
+}
+
+// Inhibited returns if the alert is inhibited by at least one other alert.
+func (a Alert) Inhibited() bool {
+	return len(a.Status.InhibitedBy) > 0
+}
+
+// TypesAlert converts the alert to the type used by Alertmanager's templates.
+func (a Alert) TypesAlert() *types.Alert {
+	labels := make(model.LabelSet, len(a.Labels))
+	for name, value := range a.Labels {
+		labels[model.LabelName(name)] = model.LabelValue(value)
+	}
+
+	annotations := make(model.LabelSet, len(a.Annotations))
+	for name, value := range a.Annotations {
+		annotations[model.LabelName(name)] = model.LabelValue(value)
+	}
+
+	return &types.Alert{
+		Alert: model.Alert{
+			Labels:       labels,
+			Annotations:  annotations,
+			StartsAt:     a.StartsAt,
+			EndsAt:       a.EndsAt,
+			GeneratorURL: a.GeneratorURL,
+		},
+		UpdatedAt: a.UpdatedAt,
+	}
 }
 
 // ListAlerts returns a slice of Alert and an error.
-func ListAlerts(logger log.Logger, alertmanagerURL string) ([]*types.Alert, error) {
-	resp, err := httpRetry(logger, http.MethodGet, alertmanagerURL+"/api/v1/alerts")
+func ListAlerts(logger log.Logger, alertmanagerURL string) ([]Alert, error) {
+	resp, err := httpRetry(logger, http.MethodGet, alertmanagerURL+"/api/v2/alerts")
+	if err != nil {
+		return nil, err
+	}
+
+	var alerts []Alert
+	dec := json.NewDecoder(resp.Body)
+	defer resp.Body.Close()
+	if err := dec.Decode(&alerts); err != nil {
+		return nil, err
+	}
+
+	return alerts, err
+}
+
+// ListAlertGroups returns a slice of AlertGroup and an error.
+func ListAlertGroups(logger log.Logger, alertmanagerURL string) ([]AlertGroup, error) {
+	resp, err := httpRetry(logger, http.MethodGet, alertmanagerURL+"/api/v2/alerts/groups")
 	if err != nil {
 		return nil, err
 	}
 
-	var alertResponse alertResponse
+	var groups []AlertGroup
 	dec := json.NewDecoder(resp.Body)
 	defer resp.Body.Close()
-	if err := dec.Decode(&alertResponse); err != nil {
+	if err := dec.Decode(&groups); err != nil {
 		return nil, err
 	}
 
-	return alertResponse.Alerts, err
+	return groups, err
 }
@@ -0,0 +1,125 @@
+package alertmanager
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// SignatureHeader carries the hex encoded HMAC-SHA256 of the webhook's body, prefixed with sha256=
+	SignatureHeader = "X-Signature-256"
+
+	// maxSignedBodySize limits the bodies read to verify their signature before they're authenticated
+	maxSignedBodySize = 10 << 20
+)
+
+// WebhookAuth configures how webhooks are authenticated.
+// A webhook is accepted if any of the configured methods succeeds.
+// Without any method configured all webhooks are accepted.
+type WebhookAuth struct {
+	// BearerToken is the token expected in the Authorization header,
+	// as sent by the Alertmanager's http_config.authorization
+	BearerToken string
+	// Username and Password are expected as HTTP basic auth
+	Username string
+	Password string
+	// HMACSecret is the key used to sign the body in the SignatureHeader
+	HMACSecret string
+}
+
+// Enabled returns whether any authentication method is configured
+func (a WebhookAuth) Enabled() bool {
+	return a.BearerToken != "" || a.Username != "" || a.Password != "" || a.HMACSecret != ""
+}
+
+// Validate returns an error if basic auth is only configured halfway
+func (a WebhookAuth) Validate() error {
+	if (a.Username == "") != (a.Password == "") {
+		return fmt.Errorf("basic auth requires both a username and a password")
+	}
+	return nil
+}
+
+// AuthenticateWebhook returns a HandlerFunc that only passes authenticated webhooks on to next.
+// Rejected webhooks are answered with 401 Unauthorized and counted.
+func AuthenticateWebhook(logger log.Logger, auth WebhookAuth, rejected prometheus.Counter, next http.HandlerFunc) http.HandlerFunc {
+	if !auth.Enabled() {
+		return next
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		var body []byte
+		if auth.HMACSecret != "" && r.Body != nil {
+			var err error
+			body, err = ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxSignedBodySize))
+			r.Body.Close()
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		}
+
+		if !auth.authenticated(r, body) {
+			level.Warn(logger).Log(
+				"msg", "rejected unauthenticated webhook",
+				"remote", r.RemoteAddr,
+			)
+			rejected.Inc()
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+func (a WebhookAuth) authenticated(r *http.Request, body []byte) bool {
+	if a.BearerToken != "" {
+		header := r.Header.Get("Authorization")
+		if strings.HasPrefix(header, "Bearer ") && equal(strings.TrimPrefix(header, "Bearer "), a.BearerToken) {
+			return true
+		}
+	}
+
+	if a.Username != "" || a.Password != "" {
+		username, password, ok := r.BasicAuth()
+		// evaluate both to not leak which one was wrong
+		validUsername := equal(username, a.Username)
+		validPassword := equal(password, a.Password)
+		if ok && validUsername && validPassword {
+			return true
+		}
+	}
+
+	if a.HMACSecret != "" {
+		signature, err := hex.DecodeString(strings.TrimPrefix(r.Header.Get(SignatureHeader), "sha256="))
+		if err == nil && hmac.Equal(signature, Sign(a.HMACSecret, body)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Sign returns the HMAC-SHA256 of the body with the secret
+func Sign(secret string, body []byte) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return mac.Sum(nil)
+}
+
+func equal(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
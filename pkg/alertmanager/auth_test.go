@@ -0,0 +1,138 @@
+package alertmanager
+
+import (
+	"bytes"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuthenticateWebhook(t *testing.T) {
+	auth := WebhookAuth{
+		BearerToken: "token",
+		Username:    "alertmanager",
+		Password:    "secret",
+		HMACSecret:  "hmac",
+	}
+
+	signed := func(secret string) func(*http.Request) {
+		return func(r *http.Request) {
+			r.Header.Set(SignatureHeader, "sha256="+hex.EncodeToString(Sign(secret, []byte(validWebhook))))
+		}
+	}
+
+	testcases := []struct {
+		name     string
+		auth     WebhookAuth
+		prepare  func(*http.Request)
+		expected int
+	}{
+		{
+			name:     "Disabled",
+			auth:     WebhookAuth{},
+			prepare:  func(r *http.Request) {},
+			expected: http.StatusOK,
+		},
+		{
+			name:     "Missing",
+			auth:     auth,
+			prepare:  func(r *http.Request) {},
+			expected: http.StatusUnauthorized,
+		},
+		{
+			name:     "BearerToken",
+			auth:     auth,
+			prepare:  func(r *http.Request) { r.Header.Set("Authorization", "Bearer token") },
+			expected: http.StatusOK,
+		},
+		{
+			name:     "WrongBearerToken",
+			auth:     auth,
+			prepare:  func(r *http.Request) { r.Header.Set("Authorization", "Bearer wrong") },
+			expected: http.StatusUnauthorized,
+		},
+		{
+			name:     "BasicAuth",
+			auth:     auth,
+			prepare:  func(r *http.Request) { r.SetBasicAuth("alertmanager", "secret") },
+			expected: http.StatusOK,
+		},
+		{
+			name:     "WrongBasicAuth",
+			auth:     auth,
+			prepare:  func(r *http.Request) { r.SetBasicAuth("alertmanager", "wrong") },
+			expected: http.StatusUnauthorized,
+		},
+		{
+			name:     "PasswordOnly",
+			auth:     WebhookAuth{Password: "secret"},
+			prepare:  func(r *http.Request) {},
+			expected: http.StatusUnauthorized,
+		},
+		{
+			name:     "Signature",
+			auth:     auth,
+			prepare:  signed("hmac"),
+			expected: http.StatusOK,
+		},
+		{
+			name:     "WrongSignature",
+			auth:     auth,
+			prepare:  signed("wrong"),
+			expected: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			rejected := prometheus.NewCounter(prometheus.CounterOpts{})
+
+			var body []byte
+			next := func(w http.ResponseWriter, r *http.Request) {
+				buf := new(bytes.Buffer)
+				buf.ReadFrom(r.Body)
+				body = buf.Bytes()
+			}
+
+			req, _ := http.NewRequest(http.MethodPost, "/", bytes.NewBufferString(validWebhook))
+			tc.prepare(req)
+
+			rec := httptest.NewRecorder()
+			AuthenticateWebhook(log.NewNopLogger(), tc.auth, rejected, next)(rec, req)
+
+			assert.Equal(t, tc.expected, rec.Code)
+			if tc.expected == http.StatusOK {
+				assert.Equal(t, validWebhook, string(body))
+				assert.Equal(t, float64(0), testutil.ToFloat64(rejected))
+			} else {
+				assert.Equal(t, float64(1), testutil.ToFloat64(rejected))
+			}
+		})
+	}
+}
+
+func TestWebhookAuthValidate(t *testing.T) {
+	assert.Nil(t, WebhookAuth{}.Validate())
+	assert.Nil(t, WebhookAuth{Username: "alertmanager", Password: "secret"}.Validate())
+	assert.NotNil(t, WebhookAuth{Username: "alertmanager"}.Validate())
+	assert.NotNil(t, WebhookAuth{Password: "secret"}.Validate())
+}
+
+func TestAuthenticateWebhookBodyLimit(t *testing.T) {
+	rejected := prometheus.NewCounter(prometheus.CounterOpts{})
+	next := func(w http.ResponseWriter, r *http.Request) {
+		t.Error("oversized webhook must not be passed on")
+	}
+
+	req, _ := http.NewRequest(http.MethodPost, "/", bytes.NewReader(make([]byte, maxSignedBodySize+1)))
+	rec := httptest.NewRecorder()
+	AuthenticateWebhook(log.NewNopLogger(), WebhookAuth{HMACSecret: "hmac"}, rejected, next)(rec, req)
+
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
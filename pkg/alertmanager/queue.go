@@ -0,0 +1,258 @@
+package alertmanager
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/libkv/store"
+	"github.com/prometheus/alertmanager/notify"
+)
+
+const (
+	webhooksDirectory = "alertmanager/webhooks"
+	// watchRetryInterval is how long to wait before watching the queue again after the store failed
+	watchRetryInterval = 5 * time.Second
+)
+
+// ErrUnknownRoute is returned for webhooks received on a route that doesn't exist
+var ErrUnknownRoute = errors.New("unknown route")
+
+// RouteLookup tells whether a route exists
+type RouteLookup interface {
+	HasRoute(name string) (bool, error)
+}
+
+// QueuedWebhook is a webhook waiting to be delivered
+type QueuedWebhook struct {
+	ID string `json:"-"`
+	// Route the webhook was received on, empty for all chats
+	Route   string                `json:"route,omitempty"`
+	Webhook notify.WebhookMessage `json:"webhook"`
+}
+
+// WebhookQueue persists webhooks in a libkv store until they were delivered,
+// so that no webhook is lost on restarts or while Telegram is unavailable.
+type WebhookQueue struct {
+	kv        store.Store
+	directory string
+	routes    RouteLookup
+	replica   string
+	pushed    chan struct{}
+
+	mu   sync.Mutex
+	last int64
+}
+
+// WebhookQueueOption passed to NewWebhookQueue to change the default instance
+type WebhookQueueOption func(q *WebhookQueue)
+
+// WithReplica sets the ID of the replica pushing to the queue, when several replicas share the kv backend.
+// It's appended to the webhooks' IDs so that replicas receiving webhooks at the same time never overwrite each other's.
+func WithReplica(id string) WebhookQueueOption {
+	return func(q *WebhookQueue) {
+		q.replica = strings.Replace(id, "/", "_", -1)
+	}
+}
+
+// NewWebhookQueue persists webhooks in the provided kv backend.
+// The namespace keeps the queues of multiple bots sharing the kv backend apart.
+// Webhooks received on routes the lookup doesn't know are rejected, all routes are accepted without it.
+func NewWebhookQueue(kv store.Store, namespace string, routes RouteLookup, opts ...WebhookQueueOption) *WebhookQueue {
+	directory := webhooksDirectory
+	if namespace != "" {
+		directory = namespace + "/" + webhooksDirectory
+	}
+	q := &WebhookQueue{
+		kv:        kv,
+		directory: directory,
+		routes:    routes,
+		pushed:    make(chan struct{}, 1),
+	}
+	for _, opt := range opts {
+		opt(q)
+	}
+	return q
+}
+
+// Push persists a webhook received on the route at the end of the queue.
+// It returns ErrUnknownRoute if the route doesn't exist.
+func (q *WebhookQueue) Push(route string, w notify.WebhookMessage) error {
+	if route != "" && q.routes != nil {
+		ok, err := q.routes.HasRoute(route)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return ErrUnknownRoute
+		}
+	}
+
+	value, err := json.Marshal(QueuedWebhook{Route: route, Webhook: w})
+	if err != nil {
+		return err
+	}
+
+	if err := q.kv.Put(q.directory+"/"+q.nextID(), value, nil); err != nil {
+		return err
+	}
+
+	q.notify()
+	return nil
+}
+
+// Pushed is notified whenever new webhooks were pushed
+func (q *WebhookQueue) Pushed() <-chan struct{} {
+	return q.pushed
+}
+
+// Watch notifies Pushed whenever the queue changed in the store, also by other processes sharing it,
+// until the context is canceled. Watching is only supported by distributed stores like consul.
+func (q *WebhookQueue) Watch(ctx context.Context) error {
+	for {
+		events, err := q.kv.WatchTree(q.directory, ctx.Done())
+		if err != nil {
+			return err
+		}
+		for range events {
+			q.notify()
+		}
+
+		// the watch ends once canceled or when the store failed, in which case it's started again
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(watchRetryInterval):
+		}
+	}
+}
+
+func (q *WebhookQueue) notify() {
+	select {
+	case q.pushed <- struct{}{}:
+	default:
+	}
+}
+
+// Pending returns all webhooks that weren't acknowledged yet, oldest first.
+// Webhooks are ordered by the clock of the replica receiving them,
+// so the order is only guaranteed for webhooks received by the same replica.
+func (q *WebhookQueue) Pending() ([]QueuedWebhook, error) {
+	kvPairs, err := q.kv.List(q.directory)
+	if err == store.ErrKeyNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var webhooks []QueuedWebhook
+	for _, kv := range kvPairs {
+		var w QueuedWebhook
+		if err := json.Unmarshal(kv.Value, &w); err != nil {
+			return nil, err
+		}
+		w.ID = kv.Key[strings.LastIndex(kv.Key, "/")+1:]
+		webhooks = append(webhooks, w)
+	}
+
+	sort.Slice(webhooks, func(i, j int) bool {
+		return webhooks[i].ID < webhooks[j].ID
+	})
+
+	return webhooks, nil
+}
+
+// Ack removes a delivered webhook from the queue
+func (q *WebhookQueue) Ack(id string) error {
+	err := q.kv.Delete(q.directory + "/" + id)
+	if err == store.ErrKeyNotFound {
+		return nil
+	}
+	return err
+}
+
+// nextID returns a unique ID sorting after all previous ones of this replica
+func (q *WebhookQueue) nextID() string {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	id := time.Now().UnixNano()
+	if id <= q.last {
+		id = q.last + 1
+	}
+	q.last = id
+
+	if q.replica != "" {
+		return fmt.Sprintf("%020d-%s", id, q.replica)
+	}
+	return fmt.Sprintf("%020d", id)
+}
+
+// PartialPushError is returned by Dispatcher.Push if the webhook was persisted by some of the bots only.
+// The webhook must not be retried as the other bots would deliver it twice.
+type PartialPushError struct {
+	Errors []string
+}
+
+func (e *PartialPushError) Error() string {
+	return fmt.Sprintf("failed to push webhook: %s", strings.Join(e.Errors, "; "))
+}
+
+// Dispatcher pushes webhooks to the queues of multiple bots by their name.
+// Webhooks sent to a route named like a bot are only pushed to that bot,
+// webhooks sent to {bot}/{route} only to that bot's route,
+// all other webhooks are pushed to every bot knowing the route.
+type Dispatcher map[string]WebhookPusher
+
+// Push the webhook to the queues of the bots it's meant for.
+// It's pushed to all of them even if some fail, the failures are returned together.
+// Once at least one bot persisted the webhook they're returned as *PartialPushError.
+// It returns ErrUnknownRoute if none of the bots knows the route.
+func (d Dispatcher) Push(route string, w notify.WebhookMessage) error {
+	if i := strings.Index(route, "/"); i >= 0 {
+		p, ok := d[route[:i]]
+		if !ok {
+			return ErrUnknownRoute
+		}
+		return p.Push(route[i+1:], w)
+	}
+	if p, ok := d[route]; ok && route != "" {
+		return p.Push("", w)
+	}
+
+	names := make([]string, 0, len(d))
+	for name := range d {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	pushed := false
+	var errs []string
+	for _, name := range names {
+		err := d[name].Push(route, w)
+		if err == ErrUnknownRoute {
+			continue
+		}
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("bot %q: %v", name, err))
+			continue
+		}
+		pushed = true
+	}
+	if len(errs) > 0 && pushed {
+		return &PartialPushError{Errors: errs}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to push webhook: %s", strings.Join(errs, "; "))
+	}
+	if !pushed && route != "" {
+		return ErrUnknownRoute
+	}
+	return nil
+}
@@ -0,0 +1,160 @@
+package alertmanager
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/docker/libkv/store"
+	"github.com/docker/libkv/store/boltdb"
+	"github.com/prometheus/alertmanager/notify"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWebhookQueue(t *testing.T) {
+	path := "/tmp/alertmanager-bot-queue.db"
+	defer os.Remove(path)
+
+	kv, err := boltdb.New([]string{path}, &store.Config{Bucket: "alertmanager"})
+	assert.Nil(t, err)
+	defer kv.Close()
+
+	q := NewWebhookQueue(kv, "", routeSet{"team-db": true})
+
+	pending, err := q.Pending()
+	assert.Nil(t, err)
+	assert.Empty(t, pending)
+
+	var webhook notify.WebhookMessage
+	assert.Nil(t, json.Unmarshal([]byte(validWebhook), &webhook))
+
+	assert.Nil(t, q.Push("", webhook))
+	webhook.GroupKey = "second"
+	assert.Nil(t, q.Push("team-db", webhook))
+
+	select {
+	case <-q.Pushed():
+	default:
+		t.Error("expected to be notified about pushed webhooks")
+	}
+
+	assert.Equal(t, ErrUnknownRoute, q.Push("team-web", webhook))
+
+	pending, err = q.Pending()
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(pending))
+	assert.Equal(t, "second", pending[1].Webhook.GroupKey)
+	assert.Equal(t, "team-db", pending[1].Route)
+	assert.Equal(t, "", pending[0].Route)
+	assert.Equal(t, "Fire", pending[0].Webhook.Alerts[0].Labels["alertname"])
+
+	assert.Nil(t, q.Ack(pending[0].ID))
+	assert.Nil(t, q.Ack(pending[0].ID))
+
+	pending, err = q.Pending()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(pending))
+	assert.Equal(t, "second", pending[0].Webhook.GroupKey)
+}
+
+func TestWebhookQueueReplicas(t *testing.T) {
+	path := "/tmp/alertmanager-bot-replicas.db"
+	defer os.Remove(path)
+
+	kv, err := boltdb.New([]string{path}, &store.Config{Bucket: "alertmanager"})
+	assert.Nil(t, err)
+	defer kv.Close()
+
+	first := NewWebhookQueue(kv, "", nil, WithReplica("first"))
+	second := NewWebhookQueue(kv, "", nil, WithReplica("second/1"))
+
+	var webhook notify.WebhookMessage
+	assert.Nil(t, json.Unmarshal([]byte(validWebhook), &webhook))
+
+	// replicas receiving webhooks at the same time don't overwrite each other's
+	first.last = time.Now().Add(time.Hour).UnixNano()
+	second.last = first.last
+	assert.Nil(t, first.Push("", webhook))
+	assert.Nil(t, second.Push("", webhook))
+
+	pending, err := first.Pending()
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(pending))
+	assert.Equal(t, fmt.Sprintf("%020d-first", first.last), pending[0].ID)
+	assert.Equal(t, fmt.Sprintf("%020d-second_1", first.last), pending[1].ID)
+}
+
+func TestDispatcher(t *testing.T) {
+	first := make(chanPusher, 2)
+	second := make(chanPusher, 2)
+	d := Dispatcher{"first": first, "second": routedPusher{second, routeSet{"team-db": true}}}
+
+	var webhook notify.WebhookMessage
+	assert.Nil(t, json.Unmarshal([]byte(validWebhook), &webhook))
+
+	assert.Nil(t, d.Push("first", webhook))
+	assert.Equal(t, 1, len(first))
+	assert.Equal(t, 0, len(second))
+	assert.Equal(t, "", (<-first).Route)
+
+	assert.Nil(t, d.Push("team-db", webhook))
+	assert.Equal(t, "team-db", (<-first).Route)
+	assert.Equal(t, "team-db", (<-second).Route)
+
+	// bots not knowing the route are skipped
+	assert.Nil(t, d.Push("team-web", webhook))
+	assert.Equal(t, "team-web", (<-first).Route)
+	assert.Equal(t, 0, len(second))
+
+	assert.Equal(t, ErrUnknownRoute, d.Push("unknown", webhook))
+	assert.Equal(t, 0, len(first))
+	assert.Equal(t, 0, len(second))
+
+	// a bot's route is only pushed to that bot
+	assert.Nil(t, d.Push("second/team-db", webhook))
+	assert.Equal(t, 0, len(first))
+	assert.Equal(t, "team-db", (<-second).Route)
+	assert.Equal(t, ErrUnknownRoute, d.Push("second/team-web", webhook))
+	assert.Equal(t, ErrUnknownRoute, d.Push("third/team-db", webhook))
+
+	// a failing bot doesn't keep the webhook from the others
+	d = Dispatcher{"first": failingPusher{}, "second": second, "third": failingPusher{}}
+	err := d.Push("", webhook)
+	assert.Equal(t, &PartialPushError{Errors: []string{`bot "first": store unavailable`, `bot "third": store unavailable`}}, err)
+	assert.EqualError(t, err, `failed to push webhook: bot "first": store unavailable; bot "third": store unavailable`)
+	assert.Equal(t, "", (<-second).Route)
+
+	d = Dispatcher{"first": failingPusher{}, "third": failingPusher{}}
+	err = d.Push("", webhook)
+	_, partial := err.(*PartialPushError)
+	assert.False(t, partial)
+	assert.EqualError(t, err, `failed to push webhook: bot "first": store unavailable; bot "third": store unavailable`)
+}
+
+type failingPusher struct{}
+
+func (failingPusher) Push(route string, w notify.WebhookMessage) error {
+	return errors.New("store unavailable")
+}
+
+type routeSet map[string]bool
+
+func (r routeSet) HasRoute(name string) (bool, error) {
+	return r[name], nil
+}
+
+// routedPusher only accepts webhooks for its routes, like a WebhookQueue with a RouteLookup
+type routedPusher struct {
+	chanPusher
+	routes routeSet
+}
+
+func (p routedPusher) Push(route string, w notify.WebhookMessage) error {
+	if route != "" && !p.routes[route] {
+		return ErrUnknownRoute
+	}
+	return p.chanPusher.Push(route, w)
+}
@@ -1,8 +1,10 @@
 package alertmanager
 
 import (
+	"bytes"
 	"context"
 	"fmt"
+	"io"
 	"net/http"
 	"time"
 
@@ -20,35 +22,54 @@ func httpBackoff() *backoff.ExponentialBackOff {
 }
 
 func httpRetry(logger log.Logger, method string, url string) (*http.Response, error) {
+	return httpRetryBody(logger, method, url, nil)
+}
+
+// httpRetryBody behaves like httpRetry but sends body as JSON with every attempt.
+// POST requests aren't idempotent and are only sent once, as a retry
+// after the Alertmanager already accepted the request would create it twice.
+func httpRetryBody(logger log.Logger, method string, url string, body []byte) (*http.Response, error) {
 	var resp *http.Response
-	var err error
 
 	fn := func() error {
-		req, err := http.NewRequest(method, url, nil)
+		req, err := http.NewRequest(method, url, bytes.NewReader(body))
 		if err != nil {
-			return err
+			return backoff.Permanent(err)
+		}
+		if body != nil {
+			req.Header.Set("Content-Type", "application/json")
 		}
 
+		// the context has to live until the caller read the response, it's canceled once the body is closed
 		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
-		defer cancel()
 		req = req.WithContext(ctx)
 
 		resp, err = http.DefaultClient.Do(req)
 		if err != nil {
+			cancel()
+			if method == http.MethodPost {
+				return backoff.Permanent(err)
+			}
 			return err
 		}
 
 		switch method {
-		case http.MethodGet:
+		case http.MethodGet, http.MethodDelete:
 			if resp.StatusCode != http.StatusOK {
-				return fmt.Errorf("status code is %d not 200", resp.StatusCode)
+				err = fmt.Errorf("status code is %d not 200", resp.StatusCode)
 			}
 		case http.MethodPost:
 			if resp.StatusCode == http.StatusBadRequest {
-				return fmt.Errorf("status code is %d not 3xx", resp.StatusCode)
+				err = backoff.Permanent(fmt.Errorf("status code is %d not 3xx", resp.StatusCode))
 			}
 		}
+		if err != nil {
+			resp.Body.Close()
+			cancel()
+			return err
+		}
 
+		resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
 		return nil
 	}
 
@@ -65,5 +86,17 @@ func httpRetry(logger log.Logger, method string, url string) (*http.Response, er
 		return nil, err
 	}
 
-	return resp, err
+	return resp, nil
+}
+
+// cancelOnClose cancels the request's context once its response body is closed
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelOnClose) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
 }
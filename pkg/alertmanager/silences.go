@@ -4,35 +4,96 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"regexp"
 	"sort"
 	"strings"
 	"time"
 
 	"github.com/go-kit/kit/log"
 	"github.com/hako/durafmt"
-	"github.com/prometheus/alertmanager/types"
 )
 
-type silencesResponse struct {
-	Data   []types.Silence `json:"data"`
-	Status string          `json:"status"`
+// Possible states of a silence.
+const (
+	SilenceStateExpired = "expired"
+	SilenceStateActive  = "active"
+	SilenceStatePending = "pending"
+)
+
+// silenceIDRegexp matches the UUIDs the Alertmanager identifies silences by
+var silenceIDRegexp = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// Silence is a silence as returned by the Alertmanager.
+type Silence struct {
+	ID        string         `json:"id,omitempty"`
+	Matchers  []Matcher      `json:"matchers"`
+	StartsAt  time.Time      `json:"startsAt"`
+	EndsAt    time.Time      `json:"endsAt"`
+	UpdatedAt time.Time      `json:"updatedAt"`
+	CreatedBy string         `json:"createdBy"`
+	Comment   string         `json:"comment"`
+	Status    *SilenceStatus `json:"status,omitempty"`
+}
+
+// Matcher matches the label of an alert by its value or a regular expression.
+// IsEqual is false for negative matchers, Alertmanagers before v0.22 don't send it.
+type Matcher struct {
+	Name    string `json:"name"`
+	Value   string `json:"value"`
+	IsRegex bool   `json:"isRegex"`
+	IsEqual *bool  `json:"isEqual,omitempty"`
+}
+
+// Operator returns the matcher's operator, one of =, !=, =~ and !~.
+func (m Matcher) Operator() string {
+	negative := m.IsEqual != nil && !*m.IsEqual
+	switch {
+	case m.IsRegex && negative:
+		return "!~"
+	case m.IsRegex:
+		return "=~"
+	case negative:
+		return "!="
+	default:
+		return "="
+	}
+}
+
+func (m Matcher) String() string {
+	return fmt.Sprintf(`%s%s"%s"`, m.Name, m.Operator(), m.Value)
+}
+
+// SilenceStatus tells if a silence is pending, active or expired.
+type SilenceStatus struct {
+	State string `json:"state"`
+}
+
+type postableSilence struct {
+	Matchers  []Matcher `json:"matchers"`
+	StartsAt  time.Time `json:"startsAt"`
+	EndsAt    time.Time `json:"endsAt"`
+	CreatedBy string    `json:"createdBy"`
+	Comment   string    `json:"comment"`
+}
+
+type createSilenceResponse struct {
+	SilenceID string `json:"silenceID"`
 }
 
 // ListSilences returns a slice of Silence and an error.
-func ListSilences(logger log.Logger, alertmanagerURL string) ([]types.Silence, error) {
-	resp, err := httpRetry(logger, http.MethodGet, alertmanagerURL+"/api/v1/silences")
+func ListSilences(logger log.Logger, alertmanagerURL string) ([]Silence, error) {
+	resp, err := httpRetry(logger, http.MethodGet, alertmanagerURL+"/api/v2/silences")
 	if err != nil {
 		return nil, err
 	}
 
-	var silencesResponse silencesResponse
+	var silences []Silence
 	dec := json.NewDecoder(resp.Body)
 	defer resp.Body.Close()
-	if err := dec.Decode(&silencesResponse); err != nil {
+	if err := dec.Decode(&silences); err != nil {
 		return nil, err
 	}
 
-	silences := silencesResponse.Data
 	sort.Slice(silences, func(i, j int) bool {
 		return silences[i].EndsAt.After(silences[j].EndsAt)
 	})
@@ -40,15 +101,89 @@ func ListSilences(logger log.Logger, alertmanagerURL string) ([]types.Silence, e
 	return silences, err
 }
 
+// CreateSilence creates a new silence and returns its ID and an error.
+func CreateSilence(logger log.Logger, alertmanagerURL string, s Silence) (string, error) {
+	body, err := json.Marshal(postableSilence{
+		Matchers:  s.Matchers,
+		StartsAt:  s.StartsAt,
+		EndsAt:    s.EndsAt,
+		CreatedBy: s.CreatedBy,
+		Comment:   s.Comment,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := httpRetryBody(logger, http.MethodPost, alertmanagerURL+"/api/v2/silences", body)
+	if err != nil {
+		return "", err
+	}
+
+	var createResponse createSilenceResponse
+	dec := json.NewDecoder(resp.Body)
+	defer resp.Body.Close()
+	if err := dec.Decode(&createResponse); err != nil {
+		return "", err
+	}
+
+	if createResponse.SilenceID == "" {
+		return "", fmt.Errorf("failed to create silence, status code is %d", resp.StatusCode)
+	}
+
+	return createResponse.SilenceID, nil
+}
+
+// GetSilence returns the Silence with the given ID and an error.
+func GetSilence(logger log.Logger, alertmanagerURL string, id string) (Silence, error) {
+	var silence Silence
+	if err := validateSilenceID(id); err != nil {
+		return silence, err
+	}
+
+	resp, err := httpRetry(logger, http.MethodGet, alertmanagerURL+"/api/v2/silence/"+id)
+	if err != nil {
+		return silence, err
+	}
+
+	dec := json.NewDecoder(resp.Body)
+	defer resp.Body.Close()
+	if err := dec.Decode(&silence); err != nil {
+		return silence, err
+	}
+
+	return silence, nil
+}
+
+// ExpireSilence expires the Silence with the given ID.
+func ExpireSilence(logger log.Logger, alertmanagerURL string, id string) error {
+	if err := validateSilenceID(id); err != nil {
+		return err
+	}
+
+	resp, err := httpRetry(logger, http.MethodDelete, alertmanagerURL+"/api/v2/silence/"+id)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// validateSilenceID makes sure the ID is a UUID, so it can't point the request to another API path
+func validateSilenceID(id string) error {
+	if !silenceIDRegexp.MatchString(id) {
+		return fmt.Errorf("invalid silence ID %q", id)
+	}
+	return nil
+}
+
 // SilenceMessage converts a silences to a message string
-func SilenceMessage(s types.Silence) string {
+func SilenceMessage(s Silence) string {
 	var alertname, emoji, matchers, duration string
 
 	for _, m := range s.Matchers {
-		if m.Name == "alertname" {
+		if m.Name == "alertname" && m.Operator() == "=" {
 			alertname = m.Value
 		} else {
-			matchers = matchers + fmt.Sprintf(` %s="%s"`, m.Name, m.Value)
+			matchers = matchers + " " + m.String()
 		}
 	}
 
@@ -69,15 +204,19 @@ func SilenceMessage(s types.Silence) string {
 	}
 
 	return fmt.Sprintf(
-		"%s%s\n```%s```\n%s\n",
+		"%s%s\n*ID:* `%s`\n```%s```\n%s\n",
 		alertname, emoji,
+		s.ID,
 		strings.TrimSpace(matchers),
 		duration,
 	)
 }
 
-// Resolved returns if a silence is resolved by EndsAt
-func Resolved(s types.Silence) bool {
+// Resolved returns if a silence is resolved by its state or EndsAt
+func Resolved(s Silence) bool {
+	if s.Status != nil && s.Status.State == SilenceStateExpired {
+		return true
+	}
 	if s.EndsAt.IsZero() {
 		return false
 	}
@@ -1,15 +1,20 @@
 package alertmanager
 
 import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
-	"github.com/prometheus/alertmanager/types"
+	"github.com/go-kit/kit/log"
 	"github.com/stretchr/testify/assert"
 )
 
 func TestResolved(t *testing.T) {
-	s := types.Silence{}
+	s := Silence{}
 	assert.False(t, Resolved(s))
 
 	s.EndsAt = time.Now().Add(time.Minute)
@@ -17,4 +22,89 @@ func TestResolved(t *testing.T) {
 
 	s.EndsAt = time.Now().Add(-1 * time.Minute)
 	assert.True(t, Resolved(s))
+
+	s.EndsAt = time.Now().Add(time.Minute)
+	s.Status = &SilenceStatus{State: SilenceStateExpired}
+	assert.True(t, Resolved(s))
+}
+
+func TestCreateSilence(t *testing.T) {
+	var received Silence
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "/api/v2/silences", r.URL.Path)
+		assert.Nil(t, json.NewDecoder(r.Body).Decode(&received))
+		w.Write([]byte(`{"silenceID":"abc"}`))
+	}))
+	defer ts.Close()
+
+	s := Silence{
+		Matchers:  []Matcher{{Name: "alertname", Value: "Fire"}},
+		StartsAt:  time.Now(),
+		EndsAt:    time.Now().Add(time.Hour),
+		CreatedBy: "test",
+		Comment:   "test",
+	}
+
+	id, err := CreateSilence(log.NewNopLogger(), ts.URL, s)
+	assert.Nil(t, err)
+	assert.Equal(t, "abc", id)
+	assert.Equal(t, "alertname", received.Matchers[0].Name)
+}
+
+func TestListSilences(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/v2/silences", r.URL.Path)
+		w.Write([]byte(`[
+			{"id":"old","matchers":[{"name":"alertname","value":"Fire","isRegex":false}],"startsAt":"2019-01-01T00:00:00Z","endsAt":"2019-01-01T01:00:00Z","createdBy":"test","comment":"test","status":{"state":"expired"}},
+			{"id":"new","matchers":[{"name":"job","value":"node.*","isRegex":true},{"name":"env","value":"dev","isRegex":false,"isEqual":false},{"name":"cluster","value":"test-.*","isRegex":true,"isEqual":false}],"startsAt":"2019-01-02T00:00:00Z","endsAt":"2019-01-02T01:00:00Z","createdBy":"test","comment":"test","status":{"state":"active"}}
+		]`))
+	}))
+	defer ts.Close()
+
+	silences, err := ListSilences(log.NewNopLogger(), ts.URL)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(silences))
+	assert.Equal(t, "new", silences[0].ID)
+	assert.True(t, silences[0].Matchers[0].IsRegex)
+	assert.Equal(t, `job=~"node.*"`, silences[0].Matchers[0].String())
+	assert.Equal(t, `env!="dev"`, silences[0].Matchers[1].String())
+	assert.Equal(t, `cluster!~"test-.*"`, silences[0].Matchers[2].String())
+	assert.Equal(t, "=", silences[1].Matchers[0].Operator())
+	assert.Equal(t, SilenceStateExpired, silences[1].Status.State)
+}
+
+func TestGetSilence(t *testing.T) {
+	id := "5a3a0a3c-6b7e-4d7a-a3e5-fa2fd0dc6b80"
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/v2/silence/"+id, r.URL.Path)
+		w.Write([]byte(`{"id":"` + id + `","matchers":[{"name":"alertname","value":"Fire","isRegex":false}],"comment":"` + strings.Repeat("x", 1<<20) + `"}`))
+	}))
+	defer ts.Close()
+
+	// the response is read after the request returned, its context must still be alive
+	silence, err := GetSilence(log.NewNopLogger(), ts.URL, id)
+	assert.Nil(t, err)
+	assert.Equal(t, id, silence.ID)
+	assert.Equal(t, 1<<20, len(silence.Comment))
+
+	_, err = GetSilence(log.NewNopLogger(), ts.URL, "../status")
+	assert.EqualError(t, err, `invalid silence ID "../status"`)
+	assert.EqualError(t, ExpireSilence(log.NewNopLogger(), ts.URL, "../../alerts"), `invalid silence ID "../../alerts"`)
+}
+
+func TestCreateSilenceNotRetried(t *testing.T) {
+	var requests int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		// the connection breaks after the silence might have been created
+		conn, _, err := w.(http.Hijacker).Hijack()
+		assert.Nil(t, err)
+		conn.Close()
+	}))
+	defer ts.Close()
+
+	_, err := CreateSilence(log.NewNopLogger(), ts.URL, Silence{Matchers: []Matcher{{Name: "alertname", Value: "Fire"}}})
+	assert.NotNil(t, err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&requests))
 }
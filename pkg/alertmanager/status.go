@@ -10,25 +10,39 @@ import (
 
 // StatusResponse is the data returned by Alertmanager about its current status.
 type StatusResponse struct {
+	Cluster ClusterStatus `json:"cluster"`
+	Config  struct {
+		Original string `json:"original"`
+	} `json:"config"`
+	Uptime      time.Time `json:"uptime"`
+	VersionInfo struct {
+		Branch    string `json:"branch"`
+		BuildDate string `json:"buildDate"`
+		BuildUser string `json:"buildUser"`
+		GoVersion string `json:"goVersion"`
+		Revision  string `json:"revision"`
+		Version   string `json:"version"`
+	} `json:"versionInfo"`
+}
+
+// ClusterStatus is the state of the Alertmanager's cluster and its peers.
+type ClusterStatus struct {
+	Name   string `json:"name"`
 	Status string `json:"status"`
-	Data   struct {
-		Uptime      time.Time `json:"uptime"`
-		VersionInfo struct {
-			Branch    string `json:"branch"`
-			BuildDate string `json:"buildDate"`
-			BuildUser string `json:"buildUser"`
-			GoVersion string `json:"goVersion"`
-			Revision  string `json:"revision"`
-			Version   string `json:"version"`
-		} `json:"versionInfo"`
-	} `json:"data"`
+	Peers  []Peer `json:"peers"`
+}
+
+// Peer is another Alertmanager in the same cluster.
+type Peer struct {
+	Name    string `json:"name"`
+	Address string `json:"address"`
 }
 
 // Status returns a StatusResponse or an error.
 func Status(logger log.Logger, alertmanagerURL string) (StatusResponse, error) {
 	var statusResponse StatusResponse
 
-	resp, err := httpRetry(logger, http.MethodGet, alertmanagerURL+"/api/v1/status")
+	resp, err := httpRetry(logger, http.MethodGet, alertmanagerURL+"/api/v2/status")
 	if err != nil {
 		return statusResponse, err
 	}
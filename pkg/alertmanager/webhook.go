@@ -3,6 +3,8 @@ package alertmanager
 import (
 	"encoding/json"
 	"net/http"
+	"regexp"
+	"strings"
 
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
@@ -10,14 +12,45 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 )
 
-// HandleWebhook returns a HandlerFunc that forwards webhooks to all bots via a channel
-func HandleWebhook(logger log.Logger, counter prometheus.Counter, webhooks chan<- notify.WebhookMessage) http.HandlerFunc {
+// WebhookRoutePrefix is the path prefix of webhooks only sent to the chats of a route
+const WebhookRoutePrefix = "/webhook/"
+
+// RouteNameRegexp matches valid names of routes
+var RouteNameRegexp = regexp.MustCompile(`^[\w-]+$`)
+
+// WebhookPusher persists received webhooks until they were delivered
+type WebhookPusher interface {
+	Push(route string, w notify.WebhookMessage) error
+}
+
+// HandleWebhook returns a HandlerFunc that persists webhooks for all bots to deliver them.
+// Webhooks received on /webhook/{route} are only delivered to the chats of that route,
+// on /webhook/{bot}/{route} only to the chats of that bot's route.
+// They're rejected with 404 Not Found if the route doesn't exist.
+// A webhook is only acknowledged to the Alertmanager once it was persisted, by at least one bot if there are multiple.
+func HandleWebhook(logger log.Logger, counter prometheus.Counter, webhooks WebhookPusher) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			w.WriteHeader(http.StatusMethodNotAllowed)
 			return
 		}
 
+		var route string
+		if strings.HasPrefix(r.URL.Path, WebhookRoutePrefix) {
+			route = strings.TrimPrefix(r.URL.Path, WebhookRoutePrefix)
+			parts := strings.Split(route, "/")
+			if len(parts) > 2 {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			for _, part := range parts {
+				if !RouteNameRegexp.MatchString(part) {
+					w.WriteHeader(http.StatusNotFound)
+					return
+				}
+			}
+		}
+
 		if r.Body == nil {
 			w.WriteHeader(http.StatusBadRequest)
 			return
@@ -39,9 +72,34 @@ func HandleWebhook(logger log.Logger, counter prometheus.Counter, webhooks chan<
 		level.Debug(logger).Log(
 			"msg", "received webhook",
 			"alerts", len(webhook.Alerts),
+			"route", route,
 		)
 
-		webhooks <- webhook
+		err = webhooks.Push(route, webhook)
+		if err == ErrUnknownRoute {
+			level.Warn(logger).Log(
+				"msg", "rejected webhook for unknown route",
+				"route", route,
+			)
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if perr, ok := err.(*PartialPushError); ok {
+			// the webhook is acknowledged anyway, the Alertmanager retrying it would deliver it twice to the other bots
+			level.Error(logger).Log(
+				"msg", "failed to persist webhook message for some bots",
+				"err", perr,
+			)
+			err = nil
+		}
+		if err != nil {
+			level.Error(logger).Log(
+				"msg", "failed to persist webhook message",
+				"err", err,
+			)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
 		counter.Inc()
 	}
 }
@@ -17,12 +17,22 @@ import (
 
 const validWebhook = `{"receiver":"telegram","status":"firing","alerts":[{"status":"firing","labels":{"alertname":"Fire","severity":"critical"},"annotations":{"message":"Something is on fire"},"startsAt":"2018-11-04T22:43:58.283995108+01:00","endsAt":"2018-11-04T22:46:58.283995108+01:00","generatorURL":"http://localhost:9090/graph?g0.expr=vector%28666%29\u0026g0.tab=1"}],"groupLabels":{"alertname":"Fire"},"commonLabels":{"alertname":"Fire","severity":"critical"},"commonAnnotations":{"message":"Something is on fire"},"externalURL":"http://localhost:9093","version":"4","groupKey":"{}:{alertname=\"Fire\"}"}`
 
+type chanPusher chan QueuedWebhook
+
+func (c chanPusher) Push(route string, w notify.WebhookMessage) error {
+	if route == "unknown" {
+		return ErrUnknownRoute
+	}
+	c <- QueuedWebhook{Route: route, Webhook: w}
+	return nil
+}
+
 func TestHandleWebhook(t *testing.T) {
 	logger := log.NewNopLogger()
 	counter := prometheus.NewCounter(prometheus.CounterOpts{})
-	webhooks := make(chan notify.WebhookMessage, 1)
+	webhooks := make(chan QueuedWebhook, 1)
 
-	h := HandleWebhook(logger, counter, webhooks)
+	h := HandleWebhook(logger, counter, chanPusher(webhooks))
 
 	type checkFunc func(*http.Response) error
 
@@ -89,13 +99,73 @@ func TestHandleWebhook(t *testing.T) {
 					}
 
 					webhook := <-webhooks
-					if !assert.Equal(t, expected, webhook) {
+					if !assert.Equal(t, QueuedWebhook{Webhook: expected}, webhook) {
+						return errors.New("")
+					}
+					return nil
+				},
+			},
+		},
+		{
+			name: "RoutedWebhook",
+			req: func() *http.Request {
+				body := bytes.NewBufferString(validWebhook)
+				req, _ := http.NewRequest(http.MethodPost, "/webhook/team-db", body)
+				return req
+			},
+			checks: []checkFunc{
+				checkStatusCode(http.StatusOK),
+
+				func(resp *http.Response) error {
+					webhook := <-webhooks
+					if !assert.Equal(t, "team-db", webhook.Route) {
+						return errors.New("")
+					}
+					return nil
+				},
+			},
+		},
+		{
+			name: "BotRoutedWebhook",
+			req: func() *http.Request {
+				body := bytes.NewBufferString(validWebhook)
+				req, _ := http.NewRequest(http.MethodPost, "/webhook/payments/team-db", body)
+				return req
+			},
+			checks: []checkFunc{
+				checkStatusCode(http.StatusOK),
+
+				func(resp *http.Response) error {
+					webhook := <-webhooks
+					if !assert.Equal(t, "payments/team-db", webhook.Route) {
 						return errors.New("")
 					}
 					return nil
 				},
 			},
 		},
+		{
+			name: "UnknownRoute",
+			req: func() *http.Request {
+				body := bytes.NewBufferString(validWebhook)
+				req, _ := http.NewRequest(http.MethodPost, "/webhook/unknown", body)
+				return req
+			},
+			checks: []checkFunc{
+				checkStatusCode(http.StatusNotFound),
+			},
+		},
+		{
+			name: "InvalidRoute",
+			req: func() *http.Request {
+				body := bytes.NewBufferString(validWebhook)
+				req, _ := http.NewRequest(http.MethodPost, "/webhook/payments/team/db", body)
+				return req
+			},
+			checks: []checkFunc{
+				checkStatusCode(http.StatusNotFound),
+			},
+		},
 	}
 
 	for _, tc := range testcases {
@@ -111,3 +181,19 @@ func TestHandleWebhook(t *testing.T) {
 		})
 	}
 }
+
+func TestHandleWebhookPartial(t *testing.T) {
+	webhooks := make(chanPusher, 1)
+	d := Dispatcher{"first": failingPusher{}, "second": webhooks}
+
+	rec := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPost, "/", bytes.NewBufferString(validWebhook))
+	HandleWebhook(log.NewNopLogger(), prometheus.NewCounter(prometheus.CounterOpts{}), d).ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, 1, len(webhooks))
+
+	rec = httptest.NewRecorder()
+	req, _ = http.NewRequest(http.MethodPost, "/", bytes.NewBufferString(validWebhook))
+	HandleWebhook(log.NewNopLogger(), prometheus.NewCounter(prometheus.CounterOpts{}), Dispatcher{"first": failingPusher{}}).ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+}
@@ -0,0 +1,163 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"regexp"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Supported store backends
+const (
+	StoreBolt   = "bolt"
+	StoreConsul = "consul"
+)
+
+var nameRegexp = regexp.MustCompile(`^[\w-]+$`)
+
+// Config of the whole process, as read from the --config.file
+type Config struct {
+	// AlertmanagerURL is used by all bots that don't have their own
+	AlertmanagerURL string `yaml:"alertmanager_url"`
+	Store           Store  `yaml:"store"`
+	// Templates are used by all bots that don't have their own
+	Templates []string `yaml:"templates"`
+	Bots      []Bot    `yaml:"bots"`
+}
+
+// Store configures the kv backend shared by all bots
+type Store struct {
+	Type      string `yaml:"type"`
+	BoltPath  string `yaml:"bolt_path"`
+	ConsulURL string `yaml:"consul_url"`
+}
+
+// Bot configures one of the bots hosted by this process
+type Bot struct {
+	// Name of the bot, the bot without a name keeps using the store's root
+	Name            string   `yaml:"name"`
+	Token           string   `yaml:"token"`
+	Admins          []int    `yaml:"admins"`
+	AlertmanagerURL string   `yaml:"alertmanager_url"`
+	Templates       []string `yaml:"templates"`
+	// Routes maps route names to the IDs of the chats receiving the webhooks sent to /webhook/{route}
+	Routes map[string][]int64 `yaml:"routes"`
+	// GroupAdmins lets the administrators of a group manage its subscription and mutes
+	GroupAdmins bool `yaml:"group_admins"`
+}
+
+// Namespace keeps the bot's data apart from other bots in the store.
+// The bot without a name keeps using the store's root to stay compatible.
+func (b Bot) Namespace() string {
+	if b.Name == "" {
+		return ""
+	}
+	return "bots/" + b.Name
+}
+
+// Load parses and validates the YAML configuration
+func Load(s []byte) (*Config, error) {
+	c := &Config{}
+	if err := yaml.UnmarshalStrict(s, c); err != nil {
+		return nil, err
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// LoadFile parses and validates the YAML configuration file
+func LoadFile(filename string) (*Config, error) {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return Load(content)
+}
+
+// Validate returns an error for the first invalid setting found
+func (c *Config) Validate() error {
+	switch c.Store.Type {
+	case StoreBolt:
+		if c.Store.BoltPath == "" {
+			return fmt.Errorf("store: bolt_path is required for the bolt store")
+		}
+	case StoreConsul:
+		if _, err := url.Parse(c.Store.ConsulURL); err != nil || c.Store.ConsulURL == "" {
+			return fmt.Errorf("store: consul_url is required to be a URL for the consul store")
+		}
+	default:
+		return fmt.Errorf("store: type has to be one of %s, %s", StoreBolt, StoreConsul)
+	}
+
+	if len(c.Bots) == 0 {
+		return fmt.Errorf("bots: at least one bot is required")
+	}
+
+	names := make(map[string]bool)
+	for i, b := range c.Bots {
+		if b.Name != "" && !nameRegexp.MatchString(b.Name) {
+			return fmt.Errorf("bots[%d]: name may only consist of letters, digits, _ and -", i)
+		}
+		if names[b.Name] {
+			return fmt.Errorf("bots[%d]: name %q is used more than once", i, b.Name)
+		}
+		names[b.Name] = true
+
+		if b.Token == "" {
+			return fmt.Errorf("bots[%d]: token is required", i)
+		}
+		if len(b.Admins) == 0 {
+			return fmt.Errorf("bots[%d]: at least one admin is required", i)
+		}
+		if _, err := c.BotAlertmanagerURL(b); err != nil {
+			return fmt.Errorf("bots[%d]: %v", i, err)
+		}
+		if len(c.BotTemplates(b)) == 0 {
+			return fmt.Errorf("bots[%d]: templates are required", i)
+		}
+		for route := range b.Routes {
+			if !nameRegexp.MatchString(route) {
+				return fmt.Errorf("bots[%d]: route %q may only consist of letters, digits, _ and -", i, route)
+			}
+		}
+	}
+
+	// /webhook/{name} is sent to the bot with that name, a route with the same name could never be reached
+	for i, b := range c.Bots {
+		for route := range b.Routes {
+			if names[route] {
+				return fmt.Errorf("bots[%d]: route %q is named like a bot, use another name", i, route)
+			}
+		}
+	}
+
+	return nil
+}
+
+// BotAlertmanagerURL returns the URL of the Alertmanager the bot talks to
+func (c *Config) BotAlertmanagerURL(b Bot) (*url.URL, error) {
+	raw := b.AlertmanagerURL
+	if raw == "" {
+		raw = c.AlertmanagerURL
+	}
+	if raw == "" {
+		return nil, fmt.Errorf("alertmanager_url is required")
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid alertmanager_url: %v", err)
+	}
+	return u, nil
+}
+
+// BotTemplates returns the paths of the templates the bot renders messages with
+func (c *Config) BotTemplates(b Bot) []string {
+	if len(b.Templates) > 0 {
+		return b.Templates
+	}
+	return c.Templates
+}
@@ -0,0 +1,97 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const validConfig = `
+alertmanager_url: http://localhost:9093
+store:
+  type: bolt
+  bolt_path: /tmp/bot.db
+templates:
+- /templates/default.tmpl
+bots:
+- token: "123:abc"
+  admins: [1, 2]
+- name: payments
+  token: "456:def"
+  admins: [3]
+  group_admins: true
+  alertmanager_url: http://payments:9093
+  templates:
+  - /templates/payments.tmpl
+  routes:
+    team-db: [-100123]
+`
+
+func TestLoad(t *testing.T) {
+	c, err := Load([]byte(validConfig))
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(c.Bots))
+
+	u, err := c.BotAlertmanagerURL(c.Bots[0])
+	assert.Nil(t, err)
+	assert.Equal(t, "http://localhost:9093", u.String())
+	u, err = c.BotAlertmanagerURL(c.Bots[1])
+	assert.Nil(t, err)
+	assert.Equal(t, "http://payments:9093", u.String())
+
+	assert.Equal(t, []string{"/templates/default.tmpl"}, c.BotTemplates(c.Bots[0]))
+	assert.Equal(t, []string{"/templates/payments.tmpl"}, c.BotTemplates(c.Bots[1]))
+
+	assert.Equal(t, "", c.Bots[0].Namespace())
+	assert.Equal(t, "bots/payments", c.Bots[1].Namespace())
+	assert.Equal(t, []int64{-100123}, c.Bots[1].Routes["team-db"])
+	assert.False(t, c.Bots[0].GroupAdmins)
+	assert.True(t, c.Bots[1].GroupAdmins)
+}
+
+func TestLoadInvalid(t *testing.T) {
+	testcases := []struct {
+		name   string
+		config string
+	}{
+		{
+			name:   "UnknownField",
+			config: "unknown: true",
+		},
+		{
+			name:   "MissingStore",
+			config: "bots: [{token: abc, admins: [1]}]\nalertmanager_url: http://localhost:9093\ntemplates: [a.tmpl]",
+		},
+		{
+			name:   "NoBots",
+			config: "store: {type: bolt, bolt_path: /tmp/bot.db}",
+		},
+		{
+			name:   "MissingAdmins",
+			config: "store: {type: bolt, bolt_path: /tmp/bot.db}\nalertmanager_url: http://localhost:9093\ntemplates: [a.tmpl]\nbots: [{token: abc}]",
+		},
+		{
+			name:   "DuplicateName",
+			config: "store: {type: bolt, bolt_path: /tmp/bot.db}\nalertmanager_url: http://localhost:9093\ntemplates: [a.tmpl]\nbots: [{token: abc, admins: [1]}, {token: def, admins: [1]}]",
+		},
+		{
+			name:   "MissingAlertmanager",
+			config: "store: {type: bolt, bolt_path: /tmp/bot.db}\ntemplates: [a.tmpl]\nbots: [{token: abc, admins: [1]}]",
+		},
+		{
+			name:   "InvalidRoute",
+			config: "store: {type: bolt, bolt_path: /tmp/bot.db}\nalertmanager_url: http://localhost:9093\ntemplates: [a.tmpl]\nbots: [{token: abc, admins: [1], routes: {team/db: [1]}}]",
+		},
+		{
+			name:   "RouteNamedLikeBot",
+			config: "store: {type: bolt, bolt_path: /tmp/bot.db}\nalertmanager_url: http://localhost:9093\ntemplates: [a.tmpl]\nbots: [{token: abc, admins: [1], routes: {payments: [1]}}, {name: payments, token: def, admins: [1]}]",
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := Load([]byte(tc.config))
+			assert.NotNil(t, err)
+		})
+	}
+}
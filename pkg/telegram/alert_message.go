@@ -0,0 +1,98 @@
+package telegram
+
+import (
+	"html"
+
+	"github.com/metalmatze/alertmanager-bot/pkg/alertmanager"
+	"github.com/prometheus/alertmanager/template"
+	"github.com/prometheus/common/model"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+const (
+	callbackSilence = "silence"
+	callbackAck     = "ack"
+)
+
+// silenceDurations are offered as inline buttons below firing alerts
+var silenceDurations = []string{"1h", "4h", "24h"}
+
+// AlertMessage links a Telegram message sent for a webhook to the alerts it shows
+type AlertMessage struct {
+	Message *telebot.Message
+	Text    string
+	Alerts  template.Alerts
+	AckedBy string
+	// SilencedBy is who silenced the message's alerts with its buttons
+	SilencedBy string
+	// Template the message was rendered with, empty for telegram.default
+	Template string
+}
+
+// ReplaceAlert replaces the message's alert having the same labels with the given alert,
+// e.g. to show it as resolved.
+func (am *AlertMessage) ReplaceAlert(alert template.Alert) {
+	fingerprint := alertFingerprint(alert)
+	for i, a := range am.Alerts {
+		if alertFingerprint(a) == fingerprint {
+			am.Alerts[i] = alert
+		}
+	}
+}
+
+// alertFingerprint identifies an alert by its labels, just like Alertmanager does.
+func alertFingerprint(alert template.Alert) string {
+	labels := make(model.LabelSet, len(alert.Labels))
+	for name, value := range alert.Labels {
+		labels[model.LabelName(name)] = model.LabelValue(value)
+	}
+	return labels.Fingerprint().String()
+}
+
+// alertKeyboard returns the inline keyboard shown below firing alerts.
+// Without ack the Ack button is left out, e.g. once an alert was acknowledged,
+// without silence the Silence buttons are, e.g. once the alerts were silenced.
+// It returns nil if there are no buttons left, which removes the keyboard when editing.
+func alertKeyboard(ack, silence bool) *telebot.ReplyMarkup {
+	var row []telebot.InlineButton
+	if silence {
+		for _, d := range silenceDurations {
+			row = append(row, telebot.InlineButton{
+				Unique: callbackSilence,
+				Text:   "Silence " + d,
+				Data:   d,
+			})
+		}
+	}
+	if ack {
+		row = append(row, telebot.InlineButton{
+			Unique: callbackAck,
+			Text:   "Ack",
+		})
+	}
+	if len(row) == 0 {
+		return nil
+	}
+	return &telebot.ReplyMarkup{InlineKeyboard: [][]telebot.InlineButton{row}}
+}
+
+// alertMatchers returns equality matchers for all labels of an alert.
+func alertMatchers(alert template.Alert) []alertmanager.Matcher {
+	var matchers []alertmanager.Matcher
+	for _, name := range alert.Labels.SortedPairs().Names() {
+		matchers = append(matchers, alertmanager.Matcher{Name: name, Value: alert.Labels[name]})
+	}
+	return matchers
+}
+
+// alertMessageText returns the message's text with notes who acknowledged and silenced its alerts.
+func alertMessageText(am AlertMessage) string {
+	text := am.Text
+	if am.AckedBy != "" {
+		text += "\n✅ <b>Acknowledged by:</b> " + html.EscapeString(am.AckedBy)
+	}
+	if am.SilencedBy != "" {
+		text += "\n🔕 <b>Silenced by:</b> " + html.EscapeString(am.SilencedBy)
+	}
+	return text
+}
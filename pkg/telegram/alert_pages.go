@@ -0,0 +1,121 @@
+package telegram
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"sort"
+	"strconv"
+
+	"github.com/metalmatze/alertmanager-bot/pkg/alertmanager"
+	"github.com/prometheus/alertmanager/template"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+const (
+	callbackAlertsPage = "alerts_page"
+)
+
+// alertsPage renders the page of all alerts matching the filter with the chat's template.
+// It returns the page's text and the keyboard to navigate to the other pages.
+func (b *Bot) alertsPage(chat *telebot.Chat, filter string, page int) (string, *telebot.ReplyMarkup, error) {
+	var matchers Matchers
+	if filter != "" {
+		var err error
+		matchers, err = ParseMatchers(filter)
+		if err != nil {
+			return "", nil, err
+		}
+	}
+
+	alerts, err := alertmanager.ListAlerts(b.logger, b.alertmanagerURL())
+	if err != nil {
+		return "", nil, err
+	}
+
+	var filtered []alertmanager.Alert
+	var firing, silenced, inhibited int
+	for _, a := range alerts {
+		if !matchers.Matches(template.KV(a.Labels)) {
+			continue
+		}
+		filtered = append(filtered, a)
+
+		if a.Status.State == alertmanager.AlertStateActive {
+			firing++
+		}
+		if a.Silenced() {
+			silenced++
+		}
+		if a.Inhibited() {
+			inhibited++
+		}
+	}
+
+	if len(filtered) == 0 {
+		if filter != "" {
+			return "No alerts matching " + html.EscapeString(filter) + " right now! 🎉", nil, nil
+		}
+		return "No alerts right now! 🎉", nil, nil
+	}
+
+	// Sort alerts to keep pages stable while navigating them
+	sort.Slice(filtered, func(i, j int) bool {
+		if !filtered[i].StartsAt.Equal(filtered[j].StartsAt) {
+			return filtered[i].StartsAt.After(filtered[j].StartsAt)
+		}
+		return filtered[i].Fingerprint < filtered[j].Fingerprint
+	})
+
+	header := fmt.Sprintf(
+		"<b>%d alerts</b>: %d firing, %d silenced, %d inhibited\n",
+		len(filtered), firing, silenced, inhibited,
+	)
+	if filter != "" {
+		header = header + "<b>Filter:</b> " + html.EscapeString(filter) + "\n"
+	}
+	if len(header) > maxMessageLength/2 {
+		return "", nil, errors.New("the filter is too long")
+	}
+
+	// every page has to fit into a message together with the header
+	pages, err := b.renderMessages(b.alertsData(filtered...), maxMessageLength-len(header), b.chatTemplate(chat))
+	if err != nil {
+		return "", nil, err
+	}
+
+	if page < 0 {
+		page = 0
+	}
+	if page >= len(pages) {
+		page = len(pages) - 1
+	}
+
+	return header + pages[page].Text, alertsPageKeyboard(page, len(pages)), nil
+}
+
+// alertsPageKeyboard returns the inline keyboard to navigate between pages of alerts.
+// The button in the middle refreshes the current page.
+func alertsPageKeyboard(page, pages int) *telebot.ReplyMarkup {
+	var row []telebot.InlineButton
+	if page > 0 {
+		row = append(row, telebot.InlineButton{
+			Unique: callbackAlertsPage,
+			Text:   "« Prev",
+			Data:   strconv.Itoa(page - 1),
+		})
+	}
+	row = append(row, telebot.InlineButton{
+		Unique: callbackAlertsPage,
+		Text:   fmt.Sprintf("%d/%d", page+1, pages),
+		Data:   strconv.Itoa(page),
+	})
+	if page < pages-1 {
+		row = append(row, telebot.InlineButton{
+			Unique: callbackAlertsPage,
+			Text:   "Next »",
+			Data:   strconv.Itoa(page + 1),
+		})
+	}
+	return &telebot.ReplyMarkup{InlineKeyboard: [][]telebot.InlineButton{row}}
+}
@@ -5,12 +5,16 @@ import (
 	"errors"
 	"fmt"
 	"github.com/robfig/cron/v3"
+	"net/http"
 	"net/url"
 	"regexp"
 	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/docker/libkv/store"
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
 	"github.com/hako/durafmt"
@@ -20,6 +24,7 @@ import (
 	"github.com/prometheus/alertmanager/template"
 	"github.com/prometheus/alertmanager/types"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
 	"gopkg.in/tucnak/telebot.v2"
 )
 
@@ -34,13 +39,23 @@ const (
 	commandSilences   	= "/silences"
 	commandMute 	  	= "/mute"
 	commandMuteDel    	= "/mute_del"
-	commandEnvironments	= "/environments"
-	commandProjects 	= "/projects"
-	commandMutedEnvs	= "/muted_envs"
-	commandMutedPrs		= "/muted_prs"
+	commandMutes		= "/mutes"
 	commandSilenceAdd 	= "/silence_add"
 	commandSilence    	= "/silence"
 	commandSilenceDel 	= "/silence_del"
+	commandSubscribe	= "/subscribe"
+	commandUnsubscribe	= "/unsubscribe"
+	commandSubscriptions	= "/subscriptions"
+	commandRouteAdd		= "/route_add"
+	commandRouteDel		= "/route_del"
+	commandRoutes		= "/routes"
+	commandReloadTemplates	= "/reload_templates"
+	commandTemplate		= "/template"
+	commandTimezone		= "/timezone"
+	commandQuietHours	= "/quiet_hours"
+	commandMode		= "/mode"
+	commandGrant		= "/grant"
+	commandRevoke		= "/revoke"
 
 	responseStart = "Hey, %s! I will now keep you up to date!\n" + commandHelp
 	responseStop  = "Alright, %s! I won't talk to you again.\n" + commandHelp
@@ -52,52 +67,94 @@ Available commands:
 ` + commandStart + ` - Subscribe for alerts.
 ` + commandStop + ` - Unsubscribe for alerts.
 ` + commandStatus + ` - Print the current status.
-` + commandAlerts + ` - List all alerts.
+` + commandAlerts + ` - List all alerts, optionally filtered by labels, e.g. ` + commandAlerts + ` severity=critical env=prod
 ` + commandSilences + ` - List all silences.
 ` + commandChats + ` - List all users and group chats that subscribed.
-` + commandMute + ` - Mute environments and/or projects.
-` + commandMuteDel + ` - Delete mute.
-` + commandEnvironments + ` - List all environments for alerts.
-` + commandProjects + ` - List all projects for alerts.
-` + commandMutedEnvs + ` - List all muted environments.
-` + commandMutedPrs + ` - List all muted projects.
+` + commandMute + ` - Mute alerts matching labels, optionally for a duration, e.g. ` + commandMute + ` environment="staging" for 2h
+` + commandMuteDel + ` - Remove a mute by its number.
+` + commandMutes + ` - List all mutes.
+` + commandSilenceAdd + ` - Add a silence, e.g. ` + commandSilenceAdd + ` 2h alertname="NodeDown" maintenance
+` + commandSilence + ` - Show a silence by its ID.
+` + commandSilenceDel + ` - Expire a silence by its ID.
+` + commandSubscribe + ` - Only get alerts matching labels, e.g. ` + commandSubscribe + ` team="payments" severity=~"critical|page"
+` + commandUnsubscribe + ` - Remove a subscription by its number.
+` + commandSubscriptions + ` - List all subscriptions.
+` + commandRouteAdd + ` - Get the webhooks sent to /webhook/<route>, e.g. ` + commandRouteAdd + ` team-db
+` + commandRouteDel + ` - Stop getting the webhooks of a route.
+` + commandRoutes + ` - List all routes.
+` + commandReloadTemplates + ` - Parse the templates again, the old ones are kept on errors.
+` + commandTemplate + ` - Choose the template alerts are sent with, e.g. ` + commandTemplate + ` compact or ` + commandTemplate + ` detailed
+` + commandTimezone + ` - Set the chat's timezone, e.g. ` + commandTimezone + ` Europe/Berlin
+` + commandQuietHours + ` - Don't notify about non-critical alerts, e.g. ` + commandQuietHours + ` 22:00-08:00 mon-fri [hold] or ` + commandQuietHours + ` off
+` + commandMode + ` - Get alerts right away or as a periodic summary, e.g. ` + commandMode + ` digest 30m or ` + commandMode + ` realtime
+` + commandGrant + ` - Grant a user the viewer, responder or admin role, e.g. ` + commandGrant + ` 123456 responder [here]
+` + commandRevoke + ` - Revoke a user's role, e.g. ` + commandRevoke + ` 123456 [here]
 `
-	ProjectAndEnvironmentMuteRegexp  = `/mute environment\[(\w+(\s*,\s*\w+)*)\],[ ]?project\[(\w+(\s*,\s*\w+)*)\]`
-	MuteProjectRegexp = `/mute project\[(\w+(\s*,\s*\w+)*)\]`
-	MuteEnvironmentRegexp = `/mute environment\[(\w+(\s*,\s*\w+)*)\]`
-	ProjectAndEnvironmentUnmuteRegexp  = `/mute_del environment\[(\w+(\s*,\s*\w+)*)\],[ ]?project\[(\w+(\s*,\s*\w+)*)\]`
-	UnmuteProjectRegexp = `/mute_del project\[(\w+(\s*,\s*\w+)*)\]`
-	UnmuteEnvironmentRegexp = `/mute_del environment\[(\w+(\s*,\s*\w+)*)\]`
-	EnvironmentValuesRegexp = `environment\[(.*?)\]`
-	ProjectValuesRegexp = `project\[(.*?)\]`
+	MuteDurationRegexp = `^for\s+(\S+)$`
+	SilenceAddRegexp = `(?s)^/silence_add(?:@\w+)?\s+(\S+)\s+(.+)$`
+
+	defaultSilenceComment = "Silenced from Telegram"
+
+	// muteExpiryInterval is how often mutes are checked for having expired
+	muteExpiryInterval = time.Minute
+	// heldAlertsInterval is how often alerts held during quiet hours or for digests are checked for being sent
+	heldAlertsInterval = time.Minute
 )
 
 // BotChatStore is all the Bot needs to store and read
 type BotChatStore interface {
 	List() ([]ChatInfo, error)
-	AddChat(*telebot.Chat, []string, []string) error
+	AddChat(*telebot.Chat) error
 	GetChatInfo(*telebot.Chat) (ChatInfo, error)
 	RemoveChat(*telebot.Chat) error
-	MuteEnvironments(*telebot.Chat, []string, []string) error
-	MuteProjects(*telebot.Chat, []string, []string) error
-	UnmuteEnvironment(*telebot.Chat, string, []string) error
-	UnmuteProject(*telebot.Chat, string, []string) error
-	MutedEnvironments(*telebot.Chat) ([]string, error)
-	MutedProjects(*telebot.Chat) ([]string, error)
+	Mute(*telebot.Chat, Matchers, time.Time) error
+	Unmute(*telebot.Chat, int) error
+	ExpireMutes(time.Time) ([]LapsedMutes, error)
+	SetTimezone(*telebot.Chat, string) error
+	SetQuietHours(*telebot.Chat, *QuietHours) error
+	HoldAlerts(*telebot.Chat, template.Data) error
+	HeldAlerts(*telebot.Chat) ([]template.Data, []string, error)
+	RemoveHeldAlerts([]string) error
+	SetMode(*telebot.Chat, string, time.Duration) error
+	SetLastDigest(*telebot.Chat, time.Time) error
+	GetUserRoles(int) (UserRoles, error)
+	GrantRole(int, int64, Role) error
+	RevokeRole(int, int64) error
+	MigrateChat(int64, int64) error
+	LeaderLock(*store.LockOptions) (store.Locker, error)
 	AddMessage(*telebot.Message) error
 	GetAllMessages() ([]telebot.Message, error)
 	GetMessagesForPeriodInMinutes(float64) ([]telebot.Message, error)
 	DeleteAllMessages() error
+	AddAlertMessage(AlertMessage) error
+	GetAlertMessage(*telebot.Message) (AlertMessage, error)
+	RemoveAlertMessage(*telebot.Message) error
+	AddAlertFingerprint(*telebot.Message, string) error
+	GetFingerprintMessage(*telebot.Chat, string) (*telebot.Message, error)
+	RemoveAlertFingerprint(*telebot.Chat, string) error
+	AddAlertsFilter(*telebot.Message, string) error
+	GetAlertsFilter(*telebot.Message) (string, error)
+	RemoveAlertsFilter(*telebot.Message) error
+	Subscribe(*telebot.Chat, Matchers) error
+	Unsubscribe(*telebot.Chat, int) error
+	SetTemplate(*telebot.Chat, string) error
+	Routes() ([]Route, error)
+	GetRoute(string) (Route, error)
+	AddRouteChat(string, *telebot.Chat) error
+	RemoveRouteChat(string, *telebot.Chat) error
+}
+
+// BotWebhookQueue is all the Bot needs to receive the persisted webhooks
+type BotWebhookQueue interface {
+	Pending() ([]alertmanager.QueuedWebhook, error)
+	Ack(string) error
+	Pushed() <-chan struct{}
 }
 
 // Bot runs the alertmanager telegram
 type Bot struct {
 	addr         			string
 	admins       			[]int // must be kept sorted
-	environments			[]string
-	projects				[]string
-	environmentsAndOther 	[]string
-	projectsAndOther		[]string
 	fetchPeriod				float64
 	deletePeriod			float64
 	alertmanager 			*url.URL
@@ -107,9 +164,32 @@ type Bot struct {
 	revision     			string
 	startTime    			time.Time
 
-	telegram *telebot.Bot
-
+	// mu guards the settings that can be reloaded while running
+	mu sync.RWMutex
+
+	templatePaths          []string
+	templateNames          map[string]bool // templates defined in templatePaths
+	templateReloadInterval time.Duration
+	// groupAdmins lets the administrators of a group manage its subscription and mutes
+	groupAdmins bool
+
+	telegram   *telebot.Bot
+	queue      *sendQueue
+	deliveries *webhookDeliveries
+	// redeliver is notified once a chat received a webhook, the webhooks deferred for it can follow
+	redeliver chan struct{}
+	// digests are the digests that were only sent partly, their remaining parts are sent on the next attempt
+	digests *unsentDigests
+	// adminsOf keeps the administrators of groups, so that not every command asks Telegram for them
+	adminsOf *groupAdminsCache
+	// updates receives the updates pushed by Telegram, nil while long polling
+	updates *updatesWebhook
+	// election elects the replica talking to Telegram, nil if there's only one
+	election *leaderElection
+
+	registerer      prometheus.Registerer
 	commandsCounter *prometheus.CounterVec
+	leader          prometheus.Gauge
 	webhooksCounter prometheus.Counter
 }
 
@@ -132,18 +212,28 @@ func NewBot(chats BotChatStore, token string, admin int, opts ...BotOption) (*Bo
 		Name:      "commands_total",
 		Help:      "Number of commands received by command name",
 	}, []string{"command"})
-	if err := prometheus.Register(commandsCounter); err != nil {
-		return nil, err
-	}
+
+	leader := prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "alertmanagerbot",
+		Name:      "leader",
+		Help:      "Whether this replica is the leader talking to Telegram, 1 for the leader and 0 for followers",
+	})
 
 	b := &Bot{
 		logger:          log.NewNopLogger(),
 		telegram:        bot,
+		queue:           newSendQueue(log.NewNopLogger()),
+		deliveries:      newWebhookDeliveries(),
+		redeliver:       make(chan struct{}, 1),
+		digests:         newUnsentDigests(),
+		adminsOf:        newGroupAdminsCache(groupAdminsTTL),
 		chats:           chats,
 		addr:            "127.0.0.1:8080",
 		admins:          []int{admin},
 		alertmanager:    &url.URL{Host: "localhost:9093"},
 		commandsCounter: commandsCounter,
+		leader:          leader,
+		registerer:      prometheus.DefaultRegisterer,
 		// TODO: initialize templates with default?
 	}
 
@@ -151,6 +241,26 @@ func NewBot(chats BotChatStore, token string, admin int, opts ...BotOption) (*Bo
 		opt(b)
 	}
 
+	names, err := definedTemplates(b.templatePaths...)
+	if err != nil {
+		return nil, err
+	}
+	b.templateNames = names
+
+	b.queue.logger = b.logger
+	if b.updates != nil {
+		b.updates.logger = b.logger
+	}
+	if b.election != nil {
+		b.election.logger = b.logger
+		b.election.leader = leader
+	}
+	for _, c := range append(b.queue.Collectors(), commandsCounter, leader) {
+		if err := b.registerer.Register(c); err != nil {
+			return nil, err
+		}
+	}
+
 	return b, nil
 }
 
@@ -161,6 +271,14 @@ func WithLogger(l log.Logger) BotOption {
 	}
 }
 
+// WithRegisterer sets the registerer for the Bot's metrics,
+// bots in the same process need to register with different labels.
+func WithRegisterer(r prometheus.Registerer) BotOption {
+	return func(b *Bot) {
+		b.registerer = r
+	}
+}
+
 // WithAddr sets the internal listening addr of the bot's web server receiving webhooks
 func WithAddr(addr string) BotOption {
 	return func(b *Bot) {
@@ -182,6 +300,20 @@ func WithTemplates(t *template.Template) BotOption {
 	}
 }
 
+// WithTemplatePaths sets the paths the templates are parsed from again when reloading them
+func WithTemplatePaths(paths ...string) BotOption {
+	return func(b *Bot) {
+		b.templatePaths = paths
+	}
+}
+
+// WithTemplateReloadInterval sets how often the template files are checked for changes, 0 disables it
+func WithTemplateReloadInterval(d time.Duration) BotOption {
+	return func(b *Bot) {
+		b.templateReloadInterval = d
+	}
+}
+
 // WithRevision is setting the Bot's revision for status commands
 func WithRevision(r string) BotOption {
 	return func(b *Bot) {
@@ -205,24 +337,43 @@ func WithExtraAdmins(ids ...int) BotOption {
 	}
 }
 
-// WithEnvironments allows to define environments that are monitored by Prometheus
-func WithEnvironments(environmentsToUse string) BotOption {
+// WithGroupAdmins lets the administrators of a group chat manage its subscription and mutes,
+// without being admins of the bot.
+func WithGroupAdmins(enabled bool) BotOption {
+	return func(b *Bot) {
+		b.groupAdmins = enabled
+	}
+}
+
+// WithUpdatesWebhook has Telegram push the bot's updates to the webhook at publicURL instead of long polling for them.
+// Telegram sends the secretToken with every update, the certificate is uploaded if it's self-signed.
+func WithUpdatesWebhook(publicURL, secretToken, certificate string) BotOption {
 	return func(b *Bot) {
-		p := strings.Replace(environmentsToUse, " ", "", -1)
-		environmentsToSave := strings.Split(p, ",")
-		b.environments = append(b.environments, environmentsToSave...)
-		b.environmentsAndOther = append(b.environments, "other")
+		b.updates = newUpdatesWebhook(publicURL, secretToken, certificate)
+		b.telegram.Poller = b.updates
 	}
 }
 
-// WithProjects allows to define projects that are monitored by Prometheus
-func WithProjects(projectsToUse string) BotOption {
+// WithLeaderElection runs the bot as one of several replicas sharing the store.
+// Only the replica holding the leader lock talks to Telegram, the lock expires after ttl once its holder is gone.
+func WithLeaderElection(id string, ttl time.Duration) BotOption {
 	return func(b *Bot) {
-		p := strings.Replace(projectsToUse, " ", "", -1)
-		projectsToSave := strings.Split(p, ",")
-		b.projects = append(b.projects, projectsToSave...)
-		b.projectsAndOther = append(b.projects, "other")
+		b.election = &leaderElection{
+			newLock: b.chats.LeaderLock,
+			id:      id,
+			ttl:     ttl,
+			retry:   leaderRetryInterval,
+		}
+	}
+}
+
+// UpdatesHandler returns the handler receiving the updates pushed by Telegram,
+// nil if the bot long polls for them.
+func (b *Bot) UpdatesHandler() http.Handler {
+	if b.updates == nil {
+		return nil
 	}
+	return b.updates
 }
 
 // WithFetchPeriod allows to define scheduler period for fetching messages from store
@@ -246,12 +397,120 @@ func (b *Bot) SendAdminMessage(adminID int, message string) {
 
 // isAdminID returns whether id is one of the configured admin IDs.
 func (b *Bot) isAdminID(id int) bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
 	i := sort.SearchInts(b.admins, id)
 	return i < len(b.admins) && b.admins[i] == id
 }
 
+// userRole returns the user's role in the chat, configured admins are admins in every chat
+func (b *Bot) userRole(userID int, chatID int64) Role {
+	if b.isAdminID(userID) {
+		return RoleAdmin
+	}
+
+	roles, err := b.chats.GetUserRoles(userID)
+	if err != nil {
+		level.Warn(b.logger).Log("msg", "failed to get user roles", "err", err)
+		return RoleNone
+	}
+	return roles.In(chatID)
+}
+
+// alertmanagerURL returns the URL of the Alertmanager currently configured
+func (b *Bot) alertmanagerURL() string {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.alertmanager.String()
+}
+
+// currentTemplates returns the templates currently used to render messages
+func (b *Bot) currentTemplates() *template.Template {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.templates
+}
+
+// Reload replaces the admins with admin and applies the options to the running bot.
+// Only the admins, group admins, Alertmanager and templates can be changed this way.
+func (b *Bot) Reload(admin int, opts ...BotOption) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.admins = []int{admin}
+	b.groupAdmins = false
+
+	for _, opt := range opts {
+		opt(b)
+	}
+
+	names, err := definedTemplates(b.templatePaths...)
+	if err != nil {
+		level.Warn(b.logger).Log("msg", "failed to find the defined templates, keeping the old ones", "err", err)
+		return
+	}
+	b.templateNames = names
+}
+
 // Run the telegram and listen to messages send to the telegram
-func (b *Bot) Run(ctx context.Context, webhooks <-chan notify.WebhookMessage) error {
+func (b *Bot) Run(ctx context.Context, webhooks BotWebhookQueue) error {
+	b.telegram.Handle(commandStart, b.handleStart)
+	b.telegram.Handle(commandStop, b.handleStop)
+	b.telegram.Handle(commandHelp, b.handleHelp)
+	b.telegram.Handle(commandChats, b.handleChats)
+	b.telegram.Handle(commandStatus, b.handleStatus)
+	b.telegram.Handle(commandAlerts, b.handleAlerts)
+	b.telegram.Handle(commandSilences, b.handleSilences)
+	b.telegram.Handle(commandMute, b.handleMute)
+	b.telegram.Handle(commandMuteDel, b.handleMuteDel)
+	b.telegram.Handle(commandMutes, b.handleMutes)
+	b.telegram.Handle(commandSilenceAdd, b.handleSilenceAdd)
+	b.telegram.Handle(commandSilence, b.handleSilence)
+	b.telegram.Handle(commandSilenceDel, b.handleSilenceDel)
+	b.telegram.Handle(commandSubscribe, b.handleSubscribe)
+	b.telegram.Handle(commandUnsubscribe, b.handleUnsubscribe)
+	b.telegram.Handle(commandSubscriptions, b.handleSubscriptions)
+	b.telegram.Handle(commandRouteAdd, b.handleRouteAdd)
+	b.telegram.Handle(commandRouteDel, b.handleRouteDel)
+	b.telegram.Handle(commandRoutes, b.handleRoutes)
+	b.telegram.Handle(commandReloadTemplates, b.handleReloadTemplates)
+	b.telegram.Handle(commandTemplate, b.handleTemplate)
+	b.telegram.Handle(commandTimezone, b.handleTimezone)
+	b.telegram.Handle(commandQuietHours, b.handleQuietHours)
+	b.telegram.Handle(commandMode, b.handleMode)
+	b.telegram.Handle(commandGrant, b.handleGrant)
+	b.telegram.Handle(commandRevoke, b.handleRevoke)
+	b.telegram.Handle(telebot.OnMigration, b.handleMigration)
+	b.telegram.Handle(&telebot.InlineButton{Unique: callbackSilence}, b.handleSilenceCallback)
+	b.telegram.Handle(&telebot.InlineButton{Unique: callbackAck}, b.handleAckCallback)
+	b.telegram.Handle(&telebot.InlineButton{Unique: callbackAlertsPage}, b.handleAlertsPageCallback)
+
+	var gr run.Group
+	{
+		gr.Add(func() error {
+			return b.watchTemplates(ctx)
+		}, func(err error) {
+		})
+	}
+	{
+		gr.Add(func() error {
+			if b.election == nil {
+				b.leader.Set(1)
+				return b.lead(ctx, webhooks)
+			}
+			return b.election.Run(ctx, func(ctx context.Context) error {
+				return b.lead(ctx, webhooks)
+			})
+		}, func(err error) {
+		})
+	}
+	return gr.Run()
+}
+
+// lead receives the updates from Telegram, delivers the webhooks and runs the scheduled jobs until ctx is canceled.
+// Only the leader of the replicas sharing the store does this, the others only queue webhooks.
+func (b *Bot) lead(ctx context.Context, webhooks BotWebhookQueue) error {
 	var gr run.Group
 	{
 		gr.Add(func() error {
@@ -261,7 +520,13 @@ func (b *Bot) Run(ctx context.Context, webhooks <-chan notify.WebhookMessage) er
 	}
 	{
 		gr.Add(func() error {
-			scheduler := cron.New(cron.WithLocation(time.UTC))
+			return b.queue.Run(ctx)
+		}, func(err error) {
+		})
+	}
+	{
+		scheduler := cron.New(cron.WithLocation(time.UTC))
+		gr.Add(func() error {
 			scheduler.AddFunc(fmt.Sprintf("@every %fm", b.fetchPeriod), func() {
 				messages, err := b.chats.GetMessagesForPeriodInMinutes(b.deletePeriod)
 				if err != nil {
@@ -273,31 +538,32 @@ func (b *Bot) Run(ctx context.Context, webhooks <-chan notify.WebhookMessage) er
 					if err != nil {
 						level.Warn(b.logger).Log("msg", "cannot delete message", err)
 					}
+					if msg.Chat != nil {
+						if err := b.chats.RemoveAlertMessage(&msg); err != nil {
+							level.Warn(b.logger).Log("msg", "cannot remove alert message from store", "err", err)
+						}
+						if err := b.chats.RemoveAlertsFilter(&msg); err != nil {
+							level.Warn(b.logger).Log("msg", "cannot remove alerts filter from store", "err", err)
+						}
+					}
 				}
 			})
+			scheduler.AddFunc(fmt.Sprintf("@every %s", muteExpiryInterval), b.expireMutes)
+			scheduler.AddFunc(fmt.Sprintf("@every %s", heldAlertsInterval), b.releaseHeldAlerts)
+			scheduler.AddFunc(fmt.Sprintf("@every %s", heldAlertsInterval), b.sendDigests)
 			scheduler.Start()
+			<-ctx.Done()
 			return nil
 		}, func(err error) {
+			scheduler.Stop()
 		})
 	}
 	{
 		gr.Add(func() error {
-			b.telegram.Handle(commandStart, b.handleStart)
-			b.telegram.Handle(commandStop, b.handleStop)
-			b.telegram.Handle(commandHelp, b.handleHelp)
-			b.telegram.Handle(commandChats, b.handleChats)
-			b.telegram.Handle(commandStatus, b.handleStatus)
-			b.telegram.Handle(commandAlerts, b.handleAlerts)
-			b.telegram.Handle(commandSilences, b.handleSilences)
-			b.telegram.Handle(commandMute, b.handleMute)
-			b.telegram.Handle(commandMuteDel, b.handleMuteDel)
-			b.telegram.Handle(commandEnvironments, b.handleEnvironments)
-			b.telegram.Handle(commandProjects, b.handleProjects)
-			b.telegram.Handle(commandMutedEnvs, b.handleMutedEnvs)
-			b.telegram.Handle(commandMutedPrs, b.handleMutedPrs)
 			b.telegram.Start()
 			return nil
 		}, func(err error) {
+			b.telegram.Stop()
 		})
 	}
 	return gr.Run()
@@ -308,7 +574,7 @@ func (b *Bot) checkMessage(message *telebot.Message) error {
 	if message.IsService() {
 		return nil
 	}
-	if !b.isAdminID(message.Sender.ID) {
+	if !b.commandAllowed(message) && !b.groupAdminAllowed(message) {
 		b.commandsCounter.WithLabelValues("dropped").Inc()
 		return fmt.Errorf("dropped message from forbidden sender")
 	}
@@ -319,72 +585,471 @@ func (b *Bot) checkMessage(message *telebot.Message) error {
 	return nil
 }
 
-// sendWebhook sends messages received via webhook to all subscribed chats
-func (b *Bot) sendWebhook(ctx context.Context, webhooks <-chan notify.WebhookMessage) error {
+func (b *Bot) checkCallback(c *telebot.Callback, unique string) error {
+	level.Debug(b.logger).Log("msg", "callback received", "data", c.Data)
+	var chatID int64
+	if c.Message != nil {
+		chatID = c.Message.Chat.ID
+	}
+	if !b.userRole(c.Sender.ID, chatID).Allows(callbackRole(unique)) {
+		b.commandsCounter.WithLabelValues("dropped").Inc()
+		return fmt.Errorf("dropped callback from forbidden sender")
+	}
+	return nil
+}
+
+// sendWebhook delivers the persisted webhooks to all subscribed chats.
+// Webhooks left over from a previous run are delivered first,
+// failed deliveries are retried until Telegram accepted all messages.
+func (b *Bot) sendWebhook(ctx context.Context, webhooks BotWebhookQueue) error {
+	ticker := time.NewTicker(webhookRedeliveryInterval)
+	defer ticker.Stop()
+
 	for {
+		b.deliverPending(webhooks)
+
 		select {
 		case <-ctx.Done():
 			return nil
-		case w := <-webhooks:
-			receiversAndMessages := make(map[telebot.Chat]template.Data)
-			for _, alert := range w.Alerts {
-				alertEnvironmentName := alert.Labels["environment"]
-				if !contains(b.environments, alertEnvironmentName) {
-					alertEnvironmentName = "other"
-				}
+		case <-webhooks.Pushed():
+		case <-b.redeliver:
+		case <-ticker.C:
+		}
+	}
+}
 
-				alertProjectName := alert.Labels["project"]
-				if !contains(b.projects, alertProjectName) {
-					alertProjectName = "other"
-				}
+// deliverPending starts delivering all pending webhooks that aren't being delivered yet.
+// A webhook is acknowledged once all of its messages were sent.
+// Every chat gets the webhooks in the order they were received, a webhook is only sent to a chat
+// once the earlier ones were, so resolved alerts find the messages they fired with.
+func (b *Bot) deliverPending(webhooks BotWebhookQueue) {
+	pending, err := webhooks.Pending()
+	if err != nil {
+		level.Error(b.logger).Log("msg", "failed to get pending webhooks from queue", "err", err)
+		return
+	}
+	if len(pending) == 0 {
+		return
+	}
 
-				chatInfos, err := b.chats.List()
-				if err != nil {
-					level.Error(b.logger).Log("msg", "failed to get chat list from store", "err", err)
+	chatInfos, err := b.chats.List()
+	if err != nil {
+		level.Error(b.logger).Log("msg", "failed to get chat list from store", "err", err)
+		return
+	}
+
+	// blocked are the chats earlier webhooks are still sent to, later webhooks are deferred for them
+	blocked := make(map[int64]bool)
+	for _, w := range pending {
+		if !b.deliveries.start(w.ID) {
+			for _, chatID := range b.deliveries.chatsSending(w.ID) {
+				blocked[chatID] = true
+			}
+			continue
+		}
+
+		chats := chatInfos
+		if w.Route != "" {
+			route, err := b.chats.GetRoute(w.Route)
+			if err != nil {
+				level.Warn(b.logger).Log("msg", "failed to get route from store", "route", w.Route, "err", err)
+				b.deliveries.finish(w.ID, false)
+				continue
+			}
+			if len(route.ChatIDs) == 0 {
+				// the route was removed after the webhook was received, no chat will ever get it
+				level.Warn(b.logger).Log("msg", "route was removed, dropping webhook", "route", w.Route, "id", w.ID)
+				b.notifyAdmins(fmt.Sprintf("Dropped a webhook with %d alerts for route %s, the route was removed.", len(w.Webhook.Alerts), w.Route))
+				if err := webhooks.Ack(w.ID); err != nil {
+					level.Warn(b.logger).Log("msg", "failed to acknowledge dropped webhook", "id", w.ID, "err", err)
+					b.deliveries.finish(w.ID, false)
 					continue
 				}
+				b.deliveries.finish(w.ID, true)
+				continue
+			}
+			chats = routeChats(route, chatInfos)
+			if len(chats) == 0 {
+				// the webhook is delivered once one of the route's chats subscribed with /start
+				level.Warn(b.logger).Log("msg", "none of the route's chats is subscribed, keeping webhook", "route", w.Route, "id", w.ID)
+				b.deliveries.finish(w.ID, false)
+				continue
+			}
+		}
 
-				for _, chatInfo := range chatInfos {
-					alertEnvs := chatInfo.AlertEnvironments
-					alertPrs := chatInfo.AlertProjects
-					if contains(alertEnvs, alertEnvironmentName) && contains(alertPrs, alertProjectName) {
-						data := &template.Data{
-							Receiver:          w.Receiver,
-							Status:            w.Status,
-							Alerts:            []template.Alert{alert},
-							GroupLabels:       w.GroupLabels,
-							CommonLabels:      w.CommonLabels,
-							CommonAnnotations: w.CommonAnnotations,
-							ExternalURL:       w.ExternalURL,
-						}
+		id := w.ID
+		b.deliverWebhook(id, w.Webhook, chats, blocked, func(err error) {
+			if err == errDeliveryDeferred {
+				level.Debug(b.logger).Log("msg", "deferred webhook until earlier ones were sent", "id", id)
+				b.deliveries.finish(id, false)
+				return
+			}
+			if err != nil {
+				level.Warn(b.logger).Log("msg", "failed to deliver webhook, retrying later", "id", id, "err", err)
+				b.deliveries.finish(id, false)
+				return
+			}
+			if err := webhooks.Ack(id); err != nil {
+				level.Warn(b.logger).Log("msg", "failed to acknowledge delivered webhook", "id", id, "err", err)
+				b.deliveries.finish(id, false)
+				return
+			}
+			b.deliveries.finish(id, true)
+		})
+	}
+}
 
-						if _, exists := receiversAndMessages[*chatInfo.Chat]; exists {
-							data.Alerts = append(data.Alerts, receiversAndMessages[*chatInfo.Chat].Alerts...)
-							receiversAndMessages[*chatInfo.Chat] = *data
-						} else {
-							receiversAndMessages[*chatInfo.Chat] = *data
-						}
-					}
-				}
+// deliverWebhook sends the webhook's alerts to all subscribed chats that didn't receive them yet.
+// Blocked chats are skipped and the chats it's sent to are blocked for the webhooks after it.
+// done is called once all messages were sent, with an error if the webhook should be delivered again.
+func (b *Bot) deliverWebhook(id string, w notify.WebhookMessage, chatInfos []ChatInfo, blocked map[int64]bool, done func(error)) {
+	receiversAndMessages := make(map[telebot.Chat]template.Data)
+	infos := make(map[int64]ChatInfo)
+	for _, alert := range w.Alerts {
+		for _, chatInfo := range chatInfos {
+			if b.deliveries.isDelivered(id, chatInfo.Chat.ID) {
+				continue
+			}
+			if !chatInfo.Subscribed(alert.Labels) {
+				continue
 			}
 
-			for k, v := range receiversAndMessages {
-				out, err := b.templates.ExecuteHTMLString(`{{ template "telegram.default" . }}`, v)
-				if err != nil {
-					level.Warn(b.logger).Log("msg", "failed to template alerts", "err", err)
-					continue
+			// Mutes apply to chats with subscriptions too: subscriptions choose the alerts a chat gets,
+			// mutes hide some of them for a while without touching the subscriptions.
+			if !chatInfo.Muted(alert.Labels) {
+				data := &template.Data{
+					Receiver:          w.Receiver,
+					Status:            w.Status,
+					Alerts:            []template.Alert{alert},
+					GroupLabels:       w.GroupLabels,
+					CommonLabels:      w.CommonLabels,
+					CommonAnnotations: w.CommonAnnotations,
+					ExternalURL:       w.ExternalURL,
 				}
-				msg, err := b.telegram.Send(&telebot.Chat{ID: k.ID}, b.truncateMessage(out), &telebot.SendOptions{ParseMode: telebot.ModeHTML})
-				if err != nil {
-					level.Warn(b.logger).Log("msg", "failed to send message to subscribed chat", "err", err)
+
+				infos[chatInfo.Chat.ID] = chatInfo
+				if _, exists := receiversAndMessages[*chatInfo.Chat]; exists {
+					data.Alerts = append(data.Alerts, receiversAndMessages[*chatInfo.Chat].Alerts...)
+					receiversAndMessages[*chatInfo.Chat] = *data
+				} else {
+					receiversAndMessages[*chatInfo.Chat] = *data
 				}
-				err = b.chats.AddMessage(msg)
-				if err != nil {
-					level.Warn(b.logger).Log("msg", "failed to save response message to store", err)
+			}
+		}
+	}
+
+	deferred := false
+	for chat := range receiversAndMessages {
+		if blocked[chat.ID] {
+			delete(receiversAndMessages, chat)
+			deferred = true
+			continue
+		}
+		blocked[chat.ID] = true
+	}
+
+	parts := len(receiversAndMessages)
+	if deferred {
+		parts++
+	}
+	webhookDone := newCountdown(parts, done)
+	if deferred {
+		webhookDone.finish(errDeliveryDeferred)
+	}
+
+	for k, v := range receiversAndMessages {
+		chat := k
+		b.deliveries.sending(id, chat.ID)
+		chatDone := func(err error) {
+			b.deliveries.sent(id, chat.ID)
+			if err == nil {
+				b.deliveries.markDelivered(id, chat.ID)
+				b.redeliverSoon()
+			}
+			if err != nil && !retryable(err) {
+				err = nil
+			}
+			webhookDone.finish(err)
+		}
+
+		v := v
+		info := infos[chat.ID]
+		b.editResolvedAlerts(&chat, v, func(remaining template.Alerts) {
+			v.Alerts = remaining
+
+			quiet := info.Quiet(time.Now())
+			if info.Mode == modeDigest {
+				v.Alerts = b.holdAlerts(&chat, v, true)
+			} else if quiet && info.QuietHours.Hold {
+				v.Alerts = b.holdAlerts(&chat, v, false)
+			}
+
+			if len(v.Alerts) == 0 {
+				chatDone(nil)
+				return
+			}
+			b.sendAlerts(&chat, v, info.Template, quiet, chatDone)
+		})
+	}
+}
+
+// redeliverSoon has the pending webhooks delivered again, without waiting for the next redelivery
+func (b *Bot) redeliverSoon() {
+	select {
+	case b.redeliver <- struct{}{}:
+	default:
+	}
+}
+
+// holdAlerts keeps the non-critical alerts, or all of them for digests,
+// to send them once the chat's quiet hours ended or with the next digest.
+// It returns the alerts that still need to be sent right away.
+func (b *Bot) holdAlerts(chat *telebot.Chat, data template.Data, all bool) template.Alerts {
+	held, critical := data.Alerts, template.Alerts(nil)
+	if !all {
+		held, critical = holdableAlerts(data.Alerts)
+	}
+	if len(held) == 0 {
+		return critical
+	}
+
+	data.Alerts = held
+	if err := b.chats.HoldAlerts(chat, data); err != nil {
+		level.Warn(b.logger).Log("msg", "failed to hold alerts, sending them right away", "err", err)
+		return append(critical, held...)
+	}
+	return critical
+}
+
+// releaseHeldAlerts sends the alerts held back during quiet hours as one batch to every chat whose quiet hours ended.
+// Alerts held for digests are left to sendDigests.
+func (b *Bot) releaseHeldAlerts() {
+	chatInfos, err := b.chats.List()
+	if err != nil {
+		level.Warn(b.logger).Log("msg", "failed to list chats to send held alerts", "err", err)
+		return
+	}
+
+	for _, chatInfo := range chatInfos {
+		if chatInfo.Mode == modeDigest || chatInfo.Quiet(time.Now()) {
+			continue
+		}
+
+		held, keys, err := b.chats.HeldAlerts(chatInfo.Chat)
+		if err != nil {
+			level.Warn(b.logger).Log("msg", "failed to get held alerts", "err", err)
+			continue
+		}
+		if len(held) == 0 {
+			continue
+		}
+
+		id := heldDeliveryID(chatInfo.Chat.ID)
+		if !b.deliveries.start(id) {
+			continue
+		}
+
+		chat := chatInfo.Chat
+		b.sendAlerts(chat, mergeHeldAlerts(held), chatInfo.Template, false, func(err error) {
+			// the alerts are kept for the next attempt unless all of them were sent,
+			// failing for good drops them as every attempt would fail the same way
+			if err != nil && retryable(err) {
+				b.deliveries.finish(id, false)
+				return
+			}
+			if err != nil {
+				b.dropHeldAlerts(chat, err)
+			}
+			if err := b.chats.RemoveHeldAlerts(keys); err != nil {
+				level.Warn(b.logger).Log("msg", "failed to remove held alerts", "err", err)
+			}
+			b.deliveries.finish(id, true)
+		})
+	}
+}
+
+// dropHeldAlerts tells about the alerts held for the chat that are dropped, as they can't be sent
+func (b *Bot) dropHeldAlerts(chat *telebot.Chat, err error) {
+	level.Error(b.logger).Log("msg", "dropping held alerts that can't be sent", "err", err, "chat_id", chat.ID)
+	b.notifyAdmins(fmt.Sprintf("Dropped the alerts held for chat %s, they can't be sent: %v", chatName(chat), err))
+}
+
+// sendAlerts queues the alerts rendered with the chat's template as new messages to the chat
+// and remembers which message each firing alert was sent with.
+// Alerts are rendered by severity, critical alerts are pinned and info alerts are sent silently.
+// During quiet hours all but critical alerts are sent silently.
+// done is called once all messages were sent or given up on, with the first error.
+func (b *Bot) sendAlerts(chat *telebot.Chat, data template.Data, tmplName string, quiet bool, done func(error)) {
+	type outgoing struct {
+		renderedMessage
+		template string
+		severity string
+	}
+
+	var messages []outgoing
+	for _, group := range groupBySeverity(data.Alerts) {
+		groupData := data
+		groupData.Alerts = group.Alerts
+		name := b.severityTemplate(tmplName, group.Severity)
+
+		rendered, err := b.renderMessages(groupData, maxMessageLength, name)
+		if err != nil {
+			level.Warn(b.logger).Log("msg", "failed to template alerts", "err", err)
+			done(err)
+			return
+		}
+		for _, m := range rendered {
+			messages = append(messages, outgoing{renderedMessage: m, template: name, severity: group.Severity})
+		}
+	}
+
+	sent := newCountdown(len(messages), done)
+	for _, m := range messages {
+		m := m
+		firing := m.Alerts.Firing()
+
+		options := &telebot.SendOptions{
+			ParseMode:           telebot.ModeHTML,
+			DisableNotification: m.severity == severityInfo || (quiet && m.severity != severityCritical),
+		}
+		if len(firing) > 0 {
+			options.ReplyMarkup = alertKeyboard(true, true)
+		}
+
+		send := func() (*telebot.Message, error) {
+			return b.sendMessage(chat, m.Text, options)
+		}
+		b.queue.Enqueue(chat.ID, send, func(msg *telebot.Message, err error) {
+			defer sent.finish(err)
+			if err != nil {
+				level.Warn(b.logger).Log("msg", "failed to send message to subscribed chat", "err", err)
+				b.handleSendError(chat, err)
+				return
+			}
+			err = b.chats.AddMessage(msg)
+			if err != nil {
+				level.Warn(b.logger).Log("msg", "failed to save response message to store", err)
+			}
+			if len(firing) == 0 {
+				return
+			}
+
+			if m.severity == severityCritical {
+				pin := func() (*telebot.Message, error) {
+					return msg, b.telegram.Pin(msg)
+				}
+				b.queue.Enqueue(chat.ID, pin, func(_ *telebot.Message, err error) {
+					if err != nil {
+						level.Warn(b.logger).Log("msg", "failed to pin critical alert", "err", err)
+					}
+				})
+			}
+
+			err = b.chats.AddAlertMessage(AlertMessage{Message: msg, Text: m.Text, Alerts: m.Alerts, Template: m.template})
+			if err != nil {
+				level.Warn(b.logger).Log("msg", "failed to save alert message to store", "err", err)
+				return
+			}
+			for _, alert := range firing {
+				if err := b.chats.AddAlertFingerprint(msg, alertFingerprint(alert)); err != nil {
+					level.Warn(b.logger).Log("msg", "failed to save alert fingerprint to store", "err", err)
 				}
 			}
+		})
+	}
+}
+
+// editResolvedAlerts queues edits of the messages that were sent when the now resolved alerts fired.
+// Once all of them were edited, then is called with the alerts that still need to be sent as a new message.
+func (b *Bot) editResolvedAlerts(chat *telebot.Chat, data template.Data, then func(template.Alerts)) {
+	var remaining template.Alerts
+	messages := make(map[int]*AlertMessage)
+	resolved := make(map[int]template.Alerts)
+
+	for _, alert := range data.Alerts {
+		if alert.Status != string(model.AlertResolved) {
+			remaining = append(remaining, alert)
+			continue
+		}
+
+		msg, err := b.chats.GetFingerprintMessage(chat, alertFingerprint(alert))
+		if err != nil {
+			remaining = append(remaining, alert)
+			continue
+		}
+
+		am, ok := messages[msg.ID]
+		if !ok {
+			stored, err := b.chats.GetAlertMessage(msg)
+			if err != nil {
+				remaining = append(remaining, alert)
+				continue
+			}
+			am = &stored
+			messages[msg.ID] = am
 		}
+
+		am.ReplaceAlert(alert)
+		resolved[msg.ID] = append(resolved[msg.ID], alert)
+	}
+
+	var mu sync.Mutex
+	edited := newCountdown(len(messages), func(error) {
+		then(remaining)
+	})
+	for id, am := range messages {
+		id := id
+		b.editAlertMessage(chat, am, data, func(err error) {
+			mu.Lock()
+			if err != nil {
+				level.Warn(b.logger).Log("msg", "failed to edit message of resolved alerts", "err", err)
+				remaining = append(remaining, resolved[id]...)
+			}
+			mu.Unlock()
+
+			for _, alert := range resolved[id] {
+				if err := b.chats.RemoveAlertFingerprint(chat, alertFingerprint(alert)); err != nil {
+					level.Warn(b.logger).Log("msg", "failed to remove alert fingerprint from store", "err", err)
+				}
+			}
+			edited.finish(nil)
+		})
+	}
+}
+
+// editAlertMessage renders the message's alerts again and queues editing the message in place.
+// The inline keyboard is removed once all of the message's alerts are resolved.
+// done is called once the message was edited or given up on.
+func (b *Bot) editAlertMessage(chat *telebot.Chat, am *AlertMessage, data template.Data, done func(error)) {
+	data.Alerts = am.Alerts
+	data.Status = string(model.AlertFiring)
+	firing := len(am.Alerts.Firing()) > 0
+	if !firing {
+		data.Status = string(model.AlertResolved)
+	}
+
+	out, err := b.executeTemplate(am.Template, data)
+	if err != nil {
+		done(err)
+		return
+	}
+	am.Text = b.truncateMessage(out)
+
+	options := &telebot.SendOptions{ParseMode: telebot.ModeHTML}
+	if firing {
+		options.ReplyMarkup = alertKeyboard(am.AckedBy == "", am.SilencedBy == "")
+	}
+
+	edit := func() (*telebot.Message, error) {
+		return b.telegram.Edit(am.Message, alertMessageText(*am), options)
 	}
+	b.queue.Enqueue(chat.ID, edit, func(_ *telebot.Message, err error) {
+		if err != nil {
+			done(err)
+			return
+		}
+		done(b.chats.AddAlertMessage(*am))
+	})
 }
 
 func contains(values []string, value string) bool {
@@ -405,7 +1070,7 @@ func (b *Bot) handleStart(message *telebot.Message) {
 			"sender_username", message.Sender.Username,
 		)
 	} else {
-		if err := b.chats.AddChat(message.Chat, b.environmentsAndOther, b.projectsAndOther); err != nil {
+		if err := b.chats.AddChat(message.Chat); err != nil {
 			level.Warn(b.logger).Log("msg", "failed to add chat to chat store", "err", err)
 			b.telegram.Send(message.Chat, "I can't add this chat to the subscribers list.")
 			return
@@ -495,27 +1160,21 @@ func (b *Bot) handleStatus(message *telebot.Message) {
 			"sender_username", message.Sender.Username,
 		)
 	} else {
-		s, err := alertmanager.Status(b.logger, b.alertmanager.String())
+		s, err := alertmanager.Status(b.logger, b.alertmanagerURL())
 		if err != nil {
 			level.Warn(b.logger).Log("msg", "failed to get status", "err", err)
 			b.telegram.Send(message.Chat, fmt.Sprintf("failed to get status... %v", err))
 			return
 		}
 
-		uptime := durafmt.Parse(time.Since(s.Data.Uptime))
-		uptimeBot := durafmt.Parse(time.Since(b.startTime))
+		out, mode, err := b.statusMessage(s)
+		if err != nil {
+			level.Warn(b.logger).Log("msg", "failed to render status", "err", err)
+			b.telegram.Send(message.Chat, fmt.Sprintf("failed to render status... %v", err))
+			return
+		}
 
-		b.telegram.Send(
-			message.Chat,
-			fmt.Sprintf(
-				"*AlertManager*\nVersion: %s\nUptime: %s\n*AlertManager Bot*\nVersion: %s\nUptime: %s",
-				s.Data.VersionInfo.Version,
-				uptime,
-				b.revision,
-				uptimeBot,
-			),
-			&telebot.SendOptions{ParseMode: telebot.ModeMarkdown},
-		)
+		b.telegram.Send(message.Chat, out, &telebot.SendOptions{ParseMode: mode})
 	}
 }
 
@@ -528,29 +1187,74 @@ func (b *Bot) handleAlerts(message *telebot.Message) {
 			"sender_username", message.Sender.Username,
 		)
 	} else {
-		alerts, err := alertmanager.ListAlerts(b.logger, b.alertmanager.String())
+		filter := strings.TrimSpace(message.Payload)
+
+		text, keyboard, err := b.alertsPage(message.Chat, filter, 0)
 		if err != nil {
 			b.telegram.Send(message.Chat, fmt.Sprintf("failed to list alerts... %v", err))
 			return
 		}
 
-		if len(alerts) == 0 {
-			b.telegram.Send(message.Chat, "No alerts right now! 🎉")
+		msg, err := b.telegram.Send(message.Chat, text, &telebot.SendOptions{
+			ParseMode:   telebot.ModeHTML,
+			ReplyMarkup: keyboard,
+		})
+		if err != nil {
+			level.Warn(b.logger).Log("msg", "failed to send message", "err", err)
 			return
 		}
-
-		out, err := b.tmplAlerts(alerts...)
-		if err != nil {
+		if keyboard == nil {
 			return
 		}
 
-		_, err = b.telegram.Send(message.Chat, b.truncateMessage(out), &telebot.SendOptions{
-			ParseMode: telebot.ModeHTML,
-		})
-		if err != nil {
-			level.Warn(b.logger).Log("msg", "failed to send message", "err", err)
+		if err := b.chats.AddMessage(msg); err != nil {
+			level.Warn(b.logger).Log("msg", "failed to save response message to store", "err", err)
 		}
+		if err := b.chats.AddAlertsFilter(msg, filter); err != nil {
+			level.Warn(b.logger).Log("msg", "failed to save alerts filter to store", "err", err)
+		}
+	}
+}
+
+func (b *Bot) handleAlertsPageCallback(c *telebot.Callback) {
+	if err := b.checkCallback(c, callbackAlertsPage); err != nil {
+		level.Info(b.logger).Log(
+			"msg", "failed to process callback",
+			"err", err,
+			"sender_id", c.Sender.ID,
+			"sender_username", c.Sender.Username,
+		)
+		b.telegram.Respond(c, &telebot.CallbackResponse{Text: "You are not allowed to list alerts."})
+		return
+	}
+
+	page, err := strconv.Atoi(c.Data)
+	if err != nil {
+		b.telegram.Respond(c, &telebot.CallbackResponse{Text: fmt.Sprintf("invalid page %q", c.Data)})
+		return
+	}
+
+	filter, err := b.chats.GetAlertsFilter(c.Message)
+	if err != nil {
+		level.Warn(b.logger).Log("msg", "failed to get alerts filter from store", "err", err)
+		b.telegram.Respond(c, &telebot.CallbackResponse{Text: "These alerts are outdated, please use " + commandAlerts + " again."})
+		return
 	}
+
+	text, keyboard, err := b.alertsPage(c.Message.Chat, filter, page)
+	if err != nil {
+		b.telegram.Respond(c, &telebot.CallbackResponse{Text: fmt.Sprintf("failed to list alerts... %v", err)})
+		return
+	}
+
+	_, err = b.telegram.Edit(c.Message, text, &telebot.SendOptions{
+		ParseMode:   telebot.ModeHTML,
+		ReplyMarkup: keyboard,
+	})
+	if err != nil {
+		level.Warn(b.logger).Log("msg", "failed to edit alerts page", "err", err)
+	}
+	b.telegram.Respond(c)
 }
 
 func (b *Bot) handleSilences(message *telebot.Message) {
@@ -562,7 +1266,7 @@ func (b *Bot) handleSilences(message *telebot.Message) {
 			"sender_username", message.Sender.Username,
 		)
 	} else {
-		silences, err := alertmanager.ListSilences(b.logger, b.alertmanager.String())
+		silences, err := alertmanager.ListSilences(b.logger, b.alertmanagerURL())
 		if err != nil {
 			b.telegram.Send(message.Chat, fmt.Sprintf("failed to list silences... %v", err))
 			return
@@ -573,16 +1277,18 @@ func (b *Bot) handleSilences(message *telebot.Message) {
 			return
 		}
 
-		var out string
-		for _, silence := range silences {
-			out = out + alertmanager.SilenceMessage(silence) + "\n"
+		out, mode, err := b.silencesMessage(silences...)
+		if err != nil {
+			level.Warn(b.logger).Log("msg", "failed to render silences", "err", err)
+			b.telegram.Send(message.Chat, fmt.Sprintf("failed to render silences... %v", err))
+			return
 		}
 
-		b.telegram.Send(message.Chat, out, &telebot.SendOptions{ParseMode: telebot.ModeMarkdown})
+		b.telegram.Send(message.Chat, out, &telebot.SendOptions{ParseMode: mode})
 	}
 }
 
-func (b *Bot) handleMute(message *telebot.Message) {
+func (b *Bot) handleSilenceAdd(message *telebot.Message) {
 	if err := b.checkMessage(message); err != nil {
 		level.Info(b.logger).Log(
 			"msg", "failed to process message",
@@ -591,33 +1297,489 @@ func (b *Bot) handleMute(message *telebot.Message) {
 			"sender_username", message.Sender.Username,
 		)
 	} else {
-		envsToMute, prsToMute, err := parseMuteCommand(message.Text)
+		duration, matchers, comment, err := parseSilenceCommand(message.Text)
 		if err != nil {
-			b.telegram.Send(message.Chat, fmt.Sprintf("failed to parse mute command... %v", err))
+			b.telegram.Send(message.Chat, fmt.Sprintf("failed to parse silence command... %v", err))
 			return
 		}
 
-		if len(envsToMute) > 0 {
-			err := b.chats.MuteEnvironments(message.Chat, envsToMute, b.environmentsAndOther)
-			if err != nil {
-				level.Warn(b.logger).Log("msg", "failed to subscribe user to environments", "err", err)
-				b.telegram.Send(message.Chat, fmt.Sprintf("failed to subscribe user to environments... %v", err))
+		if comment == "" {
+			comment = defaultSilenceComment
+		}
+
+		now := time.Now()
+		silence := alertmanager.Silence{
+			Matchers:  matchers,
+			StartsAt:  now,
+			EndsAt:    now.Add(duration),
+			CreatedBy: senderName(message.Sender),
+			Comment:   comment,
+		}
+
+		id, err := alertmanager.CreateSilence(b.logger, b.alertmanagerURL(), silence)
+		if err != nil {
+			level.Warn(b.logger).Log("msg", "failed to create silence", "err", err)
+			b.telegram.Send(message.Chat, fmt.Sprintf("failed to create silence... %v", err))
+			return
+		}
+
+		b.telegram.Send(message.Chat, fmt.Sprintf("Silence %s created for %s", id, durafmt.Parse(duration)))
+	}
+}
+
+func (b *Bot) handleSilence(message *telebot.Message) {
+	if err := b.checkMessage(message); err != nil {
+		level.Info(b.logger).Log(
+			"msg", "failed to process message",
+			"err", err,
+			"sender_id", message.Sender.ID,
+			"sender_username", message.Sender.Username,
+		)
+	} else {
+		id, err := parseSilenceID(message.Text)
+		if err != nil {
+			b.telegram.Send(message.Chat, fmt.Sprintf("failed to parse silence command... %v", err))
+			return
+		}
+
+		silence, err := alertmanager.GetSilence(b.logger, b.alertmanagerURL(), id)
+		if err != nil {
+			b.telegram.Send(message.Chat, fmt.Sprintf("failed to get silence... %v", err))
+			return
+		}
+
+		out, mode, err := b.silencesMessage(silence)
+		if err != nil {
+			b.telegram.Send(message.Chat, fmt.Sprintf("failed to render silence... %v", err))
+			return
+		}
+
+		b.telegram.Send(message.Chat, out, &telebot.SendOptions{ParseMode: mode})
+	}
+}
+
+func (b *Bot) handleSilenceDel(message *telebot.Message) {
+	if err := b.checkMessage(message); err != nil {
+		level.Info(b.logger).Log(
+			"msg", "failed to process message",
+			"err", err,
+			"sender_id", message.Sender.ID,
+			"sender_username", message.Sender.Username,
+		)
+	} else {
+		id, err := parseSilenceID(message.Text)
+		if err != nil {
+			b.telegram.Send(message.Chat, fmt.Sprintf("failed to parse silence command... %v", err))
+			return
+		}
+
+		if err := alertmanager.ExpireSilence(b.logger, b.alertmanagerURL(), id); err != nil {
+			level.Warn(b.logger).Log("msg", "failed to expire silence", "err", err)
+			b.telegram.Send(message.Chat, fmt.Sprintf("failed to expire silence... %v", err))
+			return
+		}
+
+		b.telegram.Send(message.Chat, fmt.Sprintf("Silence %s expired", id))
+	}
+}
+
+func (b *Bot) handleSilenceCallback(c *telebot.Callback) {
+	if err := b.checkCallback(c, callbackSilence); err != nil {
+		level.Info(b.logger).Log(
+			"msg", "failed to process callback",
+			"err", err,
+			"sender_id", c.Sender.ID,
+			"sender_username", c.Sender.Username,
+		)
+		b.telegram.Respond(c, &telebot.CallbackResponse{Text: "You are not allowed to silence alerts."})
+		return
+	}
+
+	duration, err := time.ParseDuration(c.Data)
+	if err != nil {
+		b.telegram.Respond(c, &telebot.CallbackResponse{Text: fmt.Sprintf("invalid silence duration %q", c.Data)})
+		return
+	}
+
+	am, err := b.chats.GetAlertMessage(c.Message)
+	if err != nil {
+		level.Warn(b.logger).Log("msg", "failed to get alert message from store", "err", err)
+		b.telegram.Respond(c, &telebot.CallbackResponse{Text: "I don't know the alerts of this message anymore."})
+		return
+	}
+
+	if am.SilencedBy != "" {
+		b.telegram.Respond(c, &telebot.CallbackResponse{Text: "Already silenced by " + am.SilencedBy})
+		return
+	}
+
+	firing := am.Alerts.Firing()
+	if len(firing) == 0 {
+		b.telegram.Respond(c, &telebot.CallbackResponse{Text: "There are no firing alerts to silence."})
+		return
+	}
+
+	// all alerts are silenced even if some fail, created and failed silences are reported together
+	now := time.Now()
+	var ids, errs []string
+	for _, alert := range firing {
+		silence := alertmanager.Silence{
+			Matchers:  alertMatchers(alert),
+			StartsAt:  now,
+			EndsAt:    now.Add(duration),
+			CreatedBy: senderName(c.Sender),
+			Comment:   defaultSilenceComment,
+		}
+
+		id, err := alertmanager.CreateSilence(b.logger, b.alertmanagerURL(), silence)
+		if err != nil {
+			level.Warn(b.logger).Log("msg", "failed to create silence", "err", err)
+			errs = append(errs, err.Error())
+			continue
+		}
+		ids = append(ids, id)
+	}
+
+	if len(ids) == 0 {
+		b.telegram.Respond(c, &telebot.CallbackResponse{Text: fmt.Sprintf("failed to create silences... %s", strings.Join(errs, "; "))})
+		return
+	}
+
+	// the Silence buttons are removed so that the alerts aren't silenced twice
+	am.SilencedBy = senderName(c.Sender)
+	if err := b.chats.AddAlertMessage(am); err != nil {
+		level.Warn(b.logger).Log("msg", "failed to save alert message to store", "err", err)
+	}
+	_, err = b.telegram.Edit(c.Message, alertMessageText(am), &telebot.SendOptions{
+		ParseMode:   telebot.ModeHTML,
+		ReplyMarkup: alertKeyboard(am.AckedBy == "", false),
+	})
+	if err != nil {
+		level.Warn(b.logger).Log("msg", "failed to edit silenced message", "err", err)
+	}
+
+	text := fmt.Sprintf("%s silenced this for %s: %s", senderName(c.Sender), durafmt.Parse(duration), strings.Join(ids, ", "))
+	if len(errs) > 0 {
+		b.telegram.Respond(c, &telebot.CallbackResponse{Text: fmt.Sprintf("Silenced %d of %d alerts for %s", len(ids), len(firing), durafmt.Parse(duration))})
+		text += fmt.Sprintf("\nfailed to silence %d of the alerts... %s", len(errs), strings.Join(errs, "; "))
+	} else {
+		b.telegram.Respond(c, &telebot.CallbackResponse{Text: fmt.Sprintf("Silenced for %s", durafmt.Parse(duration))})
+	}
+	b.telegram.Send(c.Message.Chat, text, &telebot.SendOptions{ReplyTo: c.Message})
+}
+
+func (b *Bot) handleAckCallback(c *telebot.Callback) {
+	if err := b.checkCallback(c, callbackAck); err != nil {
+		level.Info(b.logger).Log(
+			"msg", "failed to process callback",
+			"err", err,
+			"sender_id", c.Sender.ID,
+			"sender_username", c.Sender.Username,
+		)
+		b.telegram.Respond(c, &telebot.CallbackResponse{Text: "You are not allowed to acknowledge alerts."})
+		return
+	}
+
+	am, err := b.chats.GetAlertMessage(c.Message)
+	if err != nil {
+		level.Warn(b.logger).Log("msg", "failed to get alert message from store", "err", err)
+		b.telegram.Respond(c, &telebot.CallbackResponse{Text: "I don't know the alerts of this message anymore."})
+		return
+	}
+
+	if am.AckedBy != "" {
+		b.telegram.Respond(c, &telebot.CallbackResponse{Text: "Already acknowledged by " + am.AckedBy})
+		return
+	}
+
+	am.AckedBy = senderName(c.Sender)
+	if err := b.chats.AddAlertMessage(am); err != nil {
+		level.Warn(b.logger).Log("msg", "failed to save alert message to store", "err", err)
+		b.telegram.Respond(c, &telebot.CallbackResponse{Text: "I can't save the acknowledgement."})
+		return
+	}
+
+	_, err = b.telegram.Edit(c.Message, alertMessageText(am), &telebot.SendOptions{
+		ParseMode:   telebot.ModeHTML,
+		ReplyMarkup: alertKeyboard(false, am.SilencedBy == ""),
+	})
+	if err != nil {
+		level.Warn(b.logger).Log("msg", "failed to edit acknowledged message", "err", err)
+	}
+
+	b.telegram.Respond(c, &telebot.CallbackResponse{Text: "Acknowledged"})
+	level.Info(b.logger).Log(
+		"msg", "alert acknowledged",
+		"username", c.Sender.Username,
+		"user_id", c.Sender.ID,
+	)
+}
+
+func (b *Bot) handleSubscribe(message *telebot.Message) {
+	if err := b.checkMessage(message); err != nil {
+		level.Info(b.logger).Log(
+			"msg", "failed to process message",
+			"err", err,
+			"sender_id", message.Sender.ID,
+			"sender_username", message.Sender.Username,
+		)
+	} else {
+		matchers, err := ParseMatchers(message.Payload)
+		if err != nil {
+			b.telegram.Send(message.Chat, fmt.Sprintf("failed to parse subscribe command... %v", err))
+			return
+		}
+
+		if err := b.chats.Subscribe(message.Chat, matchers); err != nil {
+			level.Warn(b.logger).Log("msg", "failed to subscribe chat", "err", err)
+			b.telegram.Send(message.Chat, fmt.Sprintf("failed to subscribe chat... %v", err))
+			return
+		}
+
+		b.telegram.Send(message.Chat, fmt.Sprintf("This chat now gets alerts matching %s", matchers))
+	}
+}
+
+func (b *Bot) handleUnsubscribe(message *telebot.Message) {
+	if err := b.checkMessage(message); err != nil {
+		level.Info(b.logger).Log(
+			"msg", "failed to process message",
+			"err", err,
+			"sender_id", message.Sender.ID,
+			"sender_username", message.Sender.Username,
+		)
+	} else {
+		index, err := strconv.Atoi(strings.TrimSpace(message.Payload))
+		if err != nil {
+			b.telegram.Send(message.Chat, "failed to parse unsubscribe command... expected the number of a subscription, see "+commandSubscriptions)
+			return
+		}
+
+		if err := b.chats.Unsubscribe(message.Chat, index); err != nil {
+			level.Warn(b.logger).Log("msg", "failed to unsubscribe chat", "err", err)
+			b.telegram.Send(message.Chat, fmt.Sprintf("failed to unsubscribe chat... %v", err))
+			return
+		}
+
+		b.telegram.Send(message.Chat, fmt.Sprintf("Subscription %d was removed", index))
+	}
+}
+
+func (b *Bot) handleSubscriptions(message *telebot.Message) {
+	if err := b.checkMessage(message); err != nil {
+		level.Info(b.logger).Log(
+			"msg", "failed to process message",
+			"err", err,
+			"sender_id", message.Sender.ID,
+			"sender_username", message.Sender.Username,
+		)
+	} else {
+		chatInfo, err := b.chats.GetChatInfo(message.Chat)
+		if err != nil {
+			level.Warn(b.logger).Log("msg", "failed to get chat info", "err", err)
+			b.telegram.Send(message.Chat, fmt.Sprintf("failed to get subscriptions... %v", err))
+			return
+		}
+
+		if len(chatInfo.Subscriptions) == 0 {
+			b.telegram.Send(message.Chat, "No subscriptions, this chat gets all alerts")
+			return
+		}
+
+		list := ""
+		for i, matchers := range chatInfo.Subscriptions {
+			list = list + fmt.Sprintf("%d: %s\n", i+1, matchers)
+		}
+		b.telegram.Send(message.Chat, "This chat gets alerts matching any of:\n"+list)
+	}
+}
+
+func (b *Bot) handleRouteAdd(message *telebot.Message) {
+	if err := b.checkMessage(message); err != nil {
+		level.Info(b.logger).Log(
+			"msg", "failed to process message",
+			"err", err,
+			"sender_id", message.Sender.ID,
+			"sender_username", message.Sender.Username,
+		)
+	} else {
+		name := strings.TrimSpace(message.Payload)
+		if !alertmanager.RouteNameRegexp.MatchString(name) {
+			b.telegram.Send(message.Chat, "failed to parse route command... expected a route name like team-db")
+			return
+		}
+
+		if err := b.chats.AddRouteChat(name, message.Chat); err != nil {
+			level.Warn(b.logger).Log("msg", "failed to add chat to route", "err", err)
+			b.telegram.Send(message.Chat, fmt.Sprintf("failed to add chat to route... %v", err))
+			return
+		}
+
+		b.telegram.Send(message.Chat, fmt.Sprintf("This chat now gets the webhooks sent to %s%s", alertmanager.WebhookRoutePrefix, name))
+	}
+}
+
+func (b *Bot) handleRouteDel(message *telebot.Message) {
+	if err := b.checkMessage(message); err != nil {
+		level.Info(b.logger).Log(
+			"msg", "failed to process message",
+			"err", err,
+			"sender_id", message.Sender.ID,
+			"sender_username", message.Sender.Username,
+		)
+	} else {
+		name := strings.TrimSpace(message.Payload)
+		if !alertmanager.RouteNameRegexp.MatchString(name) {
+			b.telegram.Send(message.Chat, "failed to parse route command... expected a route name like team-db")
+			return
+		}
+
+		if err := b.chats.RemoveRouteChat(name, message.Chat); err != nil {
+			level.Warn(b.logger).Log("msg", "failed to remove chat from route", "err", err)
+			b.telegram.Send(message.Chat, fmt.Sprintf("failed to remove chat from route... %v", err))
+			return
+		}
+
+		b.telegram.Send(message.Chat, fmt.Sprintf("This chat no longer gets the webhooks sent to %s%s", alertmanager.WebhookRoutePrefix, name))
+	}
+}
+
+func (b *Bot) handleRoutes(message *telebot.Message) {
+	if err := b.checkMessage(message); err != nil {
+		level.Info(b.logger).Log(
+			"msg", "failed to process message",
+			"err", err,
+			"sender_id", message.Sender.ID,
+			"sender_username", message.Sender.Username,
+		)
+	} else {
+		routes, err := b.chats.Routes()
+		if err != nil {
+			level.Warn(b.logger).Log("msg", "failed to list routes", "err", err)
+			b.telegram.Send(message.Chat, fmt.Sprintf("failed to list routes... %v", err))
+			return
+		}
+
+		if len(routes) == 0 {
+			b.telegram.Send(message.Chat, "No routes, all webhooks are sent to every chat")
+			return
+		}
+
+		list := ""
+		for _, route := range routes {
+			list = list + fmt.Sprintf("%s%s: %d chats", alertmanager.WebhookRoutePrefix, route.Name, len(route.ChatIDs))
+			if route.HasChat(message.Chat.ID) {
+				list = list + " (including this one)"
 			}
+			list = list + "\n"
+		}
+		b.telegram.Send(message.Chat, "Routes:\n"+list)
+	}
+}
+
+func (b *Bot) handleReloadTemplates(message *telebot.Message) {
+	if err := b.checkMessage(message); err != nil {
+		level.Info(b.logger).Log(
+			"msg", "failed to process message",
+			"err", err,
+			"sender_id", message.Sender.ID,
+			"sender_username", message.Sender.Username,
+		)
+	} else {
+		if err := b.ReloadTemplates(); err != nil {
+			level.Warn(b.logger).Log("msg", "failed to reload templates", "err", err)
+			b.telegram.Send(message.Chat, fmt.Sprintf("failed to reload templates, keeping the old ones... %v", err))
+			return
 		}
 
-		if len(prsToMute) > 0 {
-			err := b.chats.MuteProjects(message.Chat, prsToMute, b.projectsAndOther)
+		b.telegram.Send(message.Chat, "Templates were reloaded")
+	}
+}
+
+func (b *Bot) handleTemplate(message *telebot.Message) {
+	if err := b.checkMessage(message); err != nil {
+		level.Info(b.logger).Log(
+			"msg", "failed to process message",
+			"err", err,
+			"sender_id", message.Sender.ID,
+			"sender_username", message.Sender.Username,
+		)
+	} else {
+		name := strings.TrimSpace(message.Payload)
+		if name == "" {
+			b.telegram.Send(message.Chat, fmt.Sprintf("This chat gets alerts with the %s template", templateName(b.chatTemplate(message.Chat))))
+			return
+		}
+		if !TemplateNameRegexp.MatchString(name) {
+			b.telegram.Send(message.Chat, "failed to parse template command... expected a template name like compact or detailed")
+			return
+		}
+		if name == "default" {
+			name = ""
+		}
+
+		// Make sure the template exists before any alert fails to render with it
+		if _, err := b.executeTemplate(name, b.alertsData()); err != nil {
+			b.telegram.Send(message.Chat, fmt.Sprintf("failed to use template %s... %v", templateName(name), err))
+			return
+		}
+
+		if err := b.chats.SetTemplate(message.Chat, name); err != nil {
+			level.Warn(b.logger).Log("msg", "failed to set chat template", "err", err)
+			b.telegram.Send(message.Chat, fmt.Sprintf("failed to set template... %v", err))
+			return
+		}
+
+		b.telegram.Send(message.Chat, fmt.Sprintf("This chat now gets alerts with the %s template", templateName(name)))
+	}
+}
+
+// chatTemplate returns the name of the template the chat chose, empty for the default
+func (b *Bot) chatTemplate(chat *telebot.Chat) string {
+	chatInfo, err := b.chats.GetChatInfo(chat)
+	if err != nil {
+		return ""
+	}
+	return chatInfo.Template
+}
+
+func (b *Bot) handleTimezone(message *telebot.Message) {
+	if err := b.checkMessage(message); err != nil {
+		level.Info(b.logger).Log(
+			"msg", "failed to process message",
+			"err", err,
+			"sender_id", message.Sender.ID,
+			"sender_username", message.Sender.Username,
+		)
+	} else {
+		timezone := strings.TrimSpace(message.Payload)
+		if timezone == "" {
+			chatInfo, err := b.chats.GetChatInfo(message.Chat)
 			if err != nil {
-				level.Warn(b.logger).Log("msg", "failed to subscribe user to project", "err", err)
-				b.telegram.Send(message.Chat, fmt.Sprintf("failed to subscribe user to proj... %v", err))
+				b.telegram.Send(message.Chat, fmt.Sprintf("failed to get timezone... %v", err))
+				return
 			}
+			b.telegram.Send(message.Chat, fmt.Sprintf("This chat's timezone is %s", chatInfo.Location()))
+			return
+		}
+
+		loc, err := time.LoadLocation(timezone)
+		if err != nil {
+			b.telegram.Send(message.Chat, fmt.Sprintf("failed to parse timezone command... %v", err))
+			return
 		}
 
-		b.telegram.Send(message.Chat, "You were successfully muted environments and/or projects")
+		if err := b.chats.SetTimezone(message.Chat, loc.String()); err != nil {
+			level.Warn(b.logger).Log("msg", "failed to set chat timezone", "err", err)
+			b.telegram.Send(message.Chat, fmt.Sprintf("failed to set timezone... %v", err))
+			return
+		}
+
+		b.telegram.Send(message.Chat, fmt.Sprintf("This chat's timezone is now %s", loc))
 	}
 }
 
-func (b *Bot) handleMuteDel(message *telebot.Message) {
+func (b *Bot) handleQuietHours(message *telebot.Message) {
 	if err := b.checkMessage(message); err != nil {
 		level.Info(b.logger).Log(
 			"msg", "failed to process message",
@@ -626,37 +1788,90 @@ func (b *Bot) handleMuteDel(message *telebot.Message) {
 			"sender_username", message.Sender.Username,
 		)
 	} else {
-		envsToUnmute, prsToUnmute, err := parseUnmuteCommand(message.Text)
-		if err != nil {
-			b.telegram.Send(message.Chat, fmt.Sprintf("failed to parse unmute command... %v", err))
+		payload := strings.TrimSpace(message.Payload)
+		if payload == "" {
+			chatInfo, err := b.chats.GetChatInfo(message.Chat)
+			if err != nil {
+				b.telegram.Send(message.Chat, fmt.Sprintf("failed to get quiet hours... %v", err))
+				return
+			}
+			if chatInfo.QuietHours == nil {
+				b.telegram.Send(message.Chat, "This chat has no quiet hours")
+				return
+			}
+			b.telegram.Send(message.Chat, fmt.Sprintf("This chat's quiet hours are %s %s", chatInfo.QuietHours, chatInfo.Location()))
 			return
 		}
 
-		if len(envsToUnmute) > 0 {
-			for _, env := range envsToUnmute {
-				err := b.chats.UnmuteEnvironment(message.Chat, env, b.environmentsAndOther)
-				if err != nil {
-					level.Warn(b.logger).Log("msg", "failed to unsubscribe user from an environment", "err", err)
-					b.telegram.Send(message.Chat, fmt.Sprintf("failed to unsubscribe user from an environment... %v", err))
-				}
+		var quietHours *QuietHours
+		if payload != "off" {
+			var err error
+			quietHours, err = parseQuietHours(payload)
+			if err != nil {
+				b.telegram.Send(message.Chat, fmt.Sprintf("failed to parse quiet hours command... %v", err))
+				return
 			}
 		}
 
-		if len(prsToUnmute) > 0 {
-			for _, pr := range prsToUnmute {
-				err := b.chats.UnmuteProject(message.Chat, pr, b.projectsAndOther)
-				if err != nil {
-					level.Warn(b.logger).Log("msg", "failed to unsubscribe user from a project", "err", err)
-					b.telegram.Send(message.Chat, fmt.Sprintf("failed to unsubscribe user from a project... %v", err))
-				}
+		if err := b.chats.SetQuietHours(message.Chat, quietHours); err != nil {
+			level.Warn(b.logger).Log("msg", "failed to set quiet hours", "err", err)
+			b.telegram.Send(message.Chat, fmt.Sprintf("failed to set quiet hours... %v", err))
+			return
+		}
+
+		if quietHours == nil {
+			b.telegram.Send(message.Chat, "This chat has no quiet hours anymore")
+			return
+		}
+		b.telegram.Send(message.Chat, fmt.Sprintf("This chat's quiet hours are now %s", quietHours))
+	}
+}
+
+func (b *Bot) handleMode(message *telebot.Message) {
+	if err := b.checkMessage(message); err != nil {
+		level.Info(b.logger).Log(
+			"msg", "failed to process message",
+			"err", err,
+			"sender_id", message.Sender.ID,
+			"sender_username", message.Sender.Username,
+		)
+	} else {
+		payload := strings.TrimSpace(message.Payload)
+		if payload == "" {
+			chatInfo, err := b.chats.GetChatInfo(message.Chat)
+			if err != nil {
+				b.telegram.Send(message.Chat, fmt.Sprintf("failed to get mode... %v", err))
+				return
+			}
+			if chatInfo.Mode == modeDigest {
+				b.telegram.Send(message.Chat, fmt.Sprintf("This chat gets a digest every %s", durafmt.Parse(chatInfo.DigestInterval)))
+				return
 			}
+			b.telegram.Send(message.Chat, "This chat gets alerts right away")
+			return
 		}
 
-		b.telegram.Send(message.Chat, "You were successfully delete mute from environments and/or projects")
+		mode, interval, err := parseMode(payload)
+		if err != nil {
+			b.telegram.Send(message.Chat, fmt.Sprintf("failed to parse mode command... %v", err))
+			return
+		}
+
+		if err := b.chats.SetMode(message.Chat, mode, interval); err != nil {
+			level.Warn(b.logger).Log("msg", "failed to set chat mode", "err", err)
+			b.telegram.Send(message.Chat, fmt.Sprintf("failed to set mode... %v", err))
+			return
+		}
+
+		if mode == modeDigest {
+			b.telegram.Send(message.Chat, fmt.Sprintf("This chat now gets a digest every %s", durafmt.Parse(interval)))
+			return
+		}
+		b.telegram.Send(message.Chat, "This chat now gets alerts right away")
 	}
 }
 
-func (b *Bot) handleEnvironments(message *telebot.Message) {
+func (b *Bot) handleGrant(message *telebot.Message) {
 	if err := b.checkMessage(message); err != nil {
 		level.Info(b.logger).Log(
 			"msg", "failed to process message",
@@ -665,11 +1880,28 @@ func (b *Bot) handleEnvironments(message *telebot.Message) {
 			"sender_username", message.Sender.Username,
 		)
 	} else {
-		b.telegram.Send(message.Chat, fmt.Sprintf("The following environments are available: %s", b.environmentsAndOther))
+		userID, role, chatID, err := parseGrantCommand(message.Payload, message.Chat.ID)
+		if err != nil {
+			b.telegram.Send(message.Chat, fmt.Sprintf("failed to parse grant command... %v", err))
+			return
+		}
+		if err := b.checkRoleScope(message.Sender.ID, message.Chat.ID, chatID, role); err != nil {
+			b.telegram.Send(message.Chat, fmt.Sprintf("failed to grant role... %v", err))
+			return
+		}
+
+		if err := b.chats.GrantRole(userID, chatID, role); err != nil {
+			level.Warn(b.logger).Log("msg", "failed to grant role", "err", err)
+			b.telegram.Send(message.Chat, fmt.Sprintf("failed to grant role... %v", err))
+			return
+		}
+
+		level.Info(b.logger).Log("msg", "granted role", "user_id", userID, "role", role, "chat_id", chatID, "granted_by", message.Sender.ID)
+		b.telegram.Send(message.Chat, fmt.Sprintf("User %d is now %s %s", userID, role, rolesScope(chatID)))
 	}
 }
 
-func (b *Bot) handleProjects(message *telebot.Message) {
+func (b *Bot) handleRevoke(message *telebot.Message) {
 	if err := b.checkMessage(message); err != nil {
 		level.Info(b.logger).Log(
 			"msg", "failed to process message",
@@ -678,11 +1910,36 @@ func (b *Bot) handleProjects(message *telebot.Message) {
 			"sender_username", message.Sender.Username,
 		)
 	} else {
-		b.telegram.Send(message.Chat, fmt.Sprintf("The following projects are available: %s", b.projectsAndOther))
+		userID, chatID, err := parseRevokeCommand(message.Payload, message.Chat.ID)
+		if err != nil {
+			b.telegram.Send(message.Chat, fmt.Sprintf("failed to parse revoke command... %v", err))
+			return
+		}
+		if err := b.checkRoleScope(message.Sender.ID, message.Chat.ID, chatID, RoleNone); err != nil {
+			b.telegram.Send(message.Chat, fmt.Sprintf("failed to revoke role... %v", err))
+			return
+		}
+
+		if err := b.chats.RevokeRole(userID, chatID); err != nil {
+			level.Warn(b.logger).Log("msg", "failed to revoke role", "err", err)
+			b.telegram.Send(message.Chat, fmt.Sprintf("failed to revoke role... %v", err))
+			return
+		}
+
+		level.Info(b.logger).Log("msg", "revoked role", "user_id", userID, "chat_id", chatID, "revoked_by", message.Sender.ID)
+		b.telegram.Send(message.Chat, fmt.Sprintf("User %d has no role %s anymore", userID, rolesScope(chatID)))
+	}
+}
+
+// rolesScope describes the chats a role is granted in
+func rolesScope(chatID int64) string {
+	if chatID == 0 {
+		return "in all chats"
 	}
+	return fmt.Sprintf("in chat %d", chatID)
 }
 
-func (b *Bot) handleMutedEnvs(message *telebot.Message) {
+func (b *Bot) handleMute(message *telebot.Message) {
 	if err := b.checkMessage(message); err != nil {
 		level.Info(b.logger).Log(
 			"msg", "failed to process message",
@@ -691,20 +1948,31 @@ func (b *Bot) handleMutedEnvs(message *telebot.Message) {
 			"sender_username", message.Sender.Username,
 		)
 	} else {
-		mutedEnvs, err := b.chats.MutedEnvironments(message.Chat)
+		matchers, duration, err := parseMuteCommand(message.Payload)
 		if err != nil {
-			level.Warn(b.logger).Log("msg", "failed to get muted environments", "err", err)
-			b.telegram.Send(message.Chat, fmt.Sprintf("failed to get muted environments... %v", err))
+			b.telegram.Send(message.Chat, fmt.Sprintf("failed to parse mute command... %v", err))
+			return
 		}
-		if len(mutedEnvs) > 0 {
-			b.telegram.Send(message.Chat, fmt.Sprintf("Muted environments:  %s", mutedEnvs))
-		} else {
-			b.telegram.Send(message.Chat, "No muted environments")
+
+		var until time.Time
+		if duration > 0 {
+			until = time.Now().Add(duration)
+		}
+		if err := b.chats.Mute(message.Chat, matchers, until); err != nil {
+			level.Warn(b.logger).Log("msg", "failed to mute chat", "err", err)
+			b.telegram.Send(message.Chat, fmt.Sprintf("failed to mute chat... %v", err))
+			return
 		}
+
+		if duration > 0 {
+			b.telegram.Send(message.Chat, fmt.Sprintf("Alerts matching %s are muted for %s", matchers, durafmt.Parse(duration)))
+			return
+		}
+		b.telegram.Send(message.Chat, fmt.Sprintf("Alerts matching %s are muted until %s", matchers, commandMuteDel))
 	}
 }
 
-func (b *Bot) handleMutedPrs(message *telebot.Message) {
+func (b *Bot) handleMuteDel(message *telebot.Message) {
 	if err := b.checkMessage(message); err != nil {
 		level.Info(b.logger).Log(
 			"msg", "failed to process message",
@@ -713,31 +1981,61 @@ func (b *Bot) handleMutedPrs(message *telebot.Message) {
 			"sender_username", message.Sender.Username,
 		)
 	} else {
-		mutedPrs, err := b.chats.MutedProjects(message.Chat)
+		index, err := strconv.Atoi(strings.TrimSpace(message.Payload))
 		if err != nil {
-			level.Warn(b.logger).Log("msg", "failed to get muted projects", "err", err)
-			b.telegram.Send(message.Chat, fmt.Sprintf("failed to get muted projects... %v", err))
+			b.telegram.Send(message.Chat, "failed to parse unmute command... expected the number of a mute, see "+commandMutes)
+			return
 		}
-		if len(mutedPrs) > 0 {
-			b.telegram.Send(message.Chat, fmt.Sprintf("Muted projects:  %s", mutedPrs))
-		} else {
-			b.telegram.Send(message.Chat, "No muted projects")
+
+		if err := b.chats.Unmute(message.Chat, index); err != nil {
+			level.Warn(b.logger).Log("msg", "failed to unmute chat", "err", err)
+			b.telegram.Send(message.Chat, fmt.Sprintf("failed to unmute chat... %v", err))
+			return
 		}
+
+		b.telegram.Send(message.Chat, fmt.Sprintf("Mute %d was removed", index))
 	}
 }
 
-func (b *Bot) tmplAlerts(alerts ...*types.Alert) (string, error) {
-	data := b.templates.Data("default", nil, alerts...)
+func (b *Bot) handleMutes(message *telebot.Message) {
+	if err := b.checkMessage(message); err != nil {
+		level.Info(b.logger).Log(
+			"msg", "failed to process message",
+			"err", err,
+			"sender_id", message.Sender.ID,
+			"sender_username", message.Sender.Username,
+		)
+	} else {
+		chatInfo, err := b.chats.GetChatInfo(message.Chat)
+		if err != nil {
+			level.Warn(b.logger).Log("msg", "failed to get chat info", "err", err)
+			b.telegram.Send(message.Chat, fmt.Sprintf("failed to get mutes... %v", err))
+			return
+		}
+
+		if len(chatInfo.Mutes) == 0 {
+			b.telegram.Send(message.Chat, "No mutes")
+			return
+		}
 
-	out, err := b.templates.ExecuteHTMLString(`{{ template "telegram.default" . }}`, data)
-	if err != nil {
-		return "", err
+		list := ""
+		for i, m := range chatInfo.Mutes {
+			list = list + fmt.Sprintf("%d: %s\n", i+1, m)
+		}
+		b.telegram.Send(message.Chat, "Alerts matching any of these are muted:\n"+list)
 	}
+}
 
-	return out, nil
+// alertsData prepares alerts from the Alertmanager for templating
+func (b *Bot) alertsData(alerts ...alertmanager.Alert) template.Data {
+	typesAlerts := make([]*types.Alert, 0, len(alerts))
+	for _, a := range alerts {
+		typesAlerts = append(typesAlerts, a.TypesAlert())
+	}
+	return *b.currentTemplates().Data("default", nil, typesAlerts...)
 }
 
-// Truncate very big message
+// Truncate very big message, only used when editing a message in place
 func (b *Bot) truncateMessage(str string) string {
 	truncateMsg := str
 	if len(str) > 4095 { // telegram API can only support 4096 bytes per message
@@ -755,60 +2053,108 @@ func (b *Bot) truncateMessage(str string) string {
 	return truncateMsg
 }
 
-func parseUnmuteCommand(text string) ([]string, []string, error) {
-	return parseCommands(text, ProjectAndEnvironmentUnmuteRegexp, UnmuteEnvironmentRegexp, UnmuteProjectRegexp)
-}
+// parseMuteCommand parses "<matchers...> [for <duration>]" into the matchers and the duration, 0 if there is none
+func parseMuteCommand(text string) (Matchers, time.Duration, error) {
+	matchers, rest, err := parseLeadingMatchers(text)
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(matchers) == 0 {
+		return nil, 0, errors.New(`expected <name="value"...> [for <duration>]`)
+	}
+	if rest == "" {
+		return matchers, 0, nil
+	}
 
-func parseMuteCommand(text string) ([]string, []string ,error) {
-	return parseCommands(text, ProjectAndEnvironmentMuteRegexp, MuteEnvironmentRegexp, MuteProjectRegexp)
+	match := regexp.MustCompile(MuteDurationRegexp).FindStringSubmatch(rest)
+	if match == nil {
+		return nil, 0, fmt.Errorf("unexpected %q, expected matchers like team=\"payments\" and optionally for <duration>", rest)
+	}
+	duration, err := time.ParseDuration(match[1])
+	if err != nil {
+		return nil, 0, err
+	}
+	if duration <= 0 {
+		return nil, 0, errors.New("duration must be positive")
+	}
+	return matchers, duration, nil
 }
 
-func parseCommands(text string, projectAndEnvironmentRegexp string, environmentRegexp string,
-	projectRegexp string) ([]string, []string, error) {
-	matchProjectAndEnvironment, err := regexp.MatchString(projectAndEnvironmentRegexp, text)
+// expireMutes removes all mutes that lapsed and lets their chats know
+func (b *Bot) expireMutes() {
+	lapsed, err := b.chats.ExpireMutes(time.Now())
 	if err != nil {
-		return []string{}, []string{}, err
+		level.Warn(b.logger).Log("msg", "failed to expire mutes", "err", err)
 	}
 
-	regexProject, err := regexp.Compile(ProjectValuesRegexp)
-	regexEnvironment, err := regexp.Compile(EnvironmentValuesRegexp)
+	for _, l := range lapsed {
+		muted := make([]string, 0, len(l.Mutes))
+		for _, m := range l.Mutes {
+			muted = append(muted, m.Matchers.String())
+		}
+		b.telegram.Send(l.Chat, fmt.Sprintf("The mute of %s expired, their alerts are sent again.", strings.Join(muted, " and ")))
+	}
+}
 
-	if matchProjectAndEnvironment {
-		env := strings.Replace(regexEnvironment.FindStringSubmatch(text)[1], " ", "", -1)
-		environmentsToMute := strings.Split(env, ",")
+// parseSilenceCommand parses "/silence_add <duration> <matchers...> [comment]"
+// into the silence's duration, its matchers and the optional comment.
+// The comment is whatever follows the matchers, unless it looks like another matcher,
+// so a mistyped matcher doesn't silently make the silence broader.
+func parseSilenceCommand(text string) (time.Duration, []alertmanager.Matcher, string, error) {
+	args := regexp.MustCompile(SilenceAddRegexp).FindStringSubmatch(strings.TrimSpace(text))
+	if args == nil {
+		return 0, nil, "", errors.New(`expected <duration> <name="value"...> [comment]`)
+	}
 
-		p := strings.Replace(regexProject.FindStringSubmatch(text)[1], " ", "", -1)
-		projectsToMute := strings.Split(p, ",")
-		return environmentsToMute, projectsToMute, nil
+	duration, err := time.ParseDuration(args[1])
+	if err != nil {
+		return 0, nil, "", err
+	}
+	if duration <= 0 {
+		return 0, nil, "", errors.New("duration must be positive")
 	}
 
-	matchEnvironment, err := regexp.MatchString(environmentRegexp, text)
-	if matchEnvironment {
-		env := strings.Replace(regexEnvironment.FindStringSubmatch(text)[1], " ", "", -1)
-		environmentsToMute := strings.Split(env, ",")
-		return environmentsToMute, []string{}, nil
+	parsed, comment, err := parseLeadingMatchers(args[2])
+	if err != nil {
+		return 0, nil, "", err
+	}
+	if len(parsed) == 0 {
+		return 0, nil, "", errors.New("no matchers were found")
+	}
+	if strings.ContainsAny(comment, "=~") {
+		return 0, nil, "", fmt.Errorf("unexpected %q, the comment has to follow all matchers like team=\"payments\"", comment)
 	}
 
-	matchProject, err := regexp.MatchString(projectRegexp, text)
-	if matchProject {
-		p := strings.Replace(regexProject.FindStringSubmatch(text)[1], " ", "", -1)
-		projectsToRemove := strings.Split(p, ",")
-		return []string{}, projectsToRemove, nil
+	matchers := make([]alertmanager.Matcher, 0, len(parsed))
+	for _, m := range parsed {
+		matcher := alertmanager.Matcher{
+			Name:    m.Name,
+			Value:   m.Value,
+			IsRegex: m.Type == MatchRegexp || m.Type == MatchNotRegexp,
+		}
+		if m.Type == MatchNotEqual || m.Type == MatchNotRegexp {
+			isEqual := false
+			matcher.IsEqual = &isEqual
+		}
+		matchers = append(matchers, matcher)
 	}
 
-	return []string{}, []string{}, errors.New("no matches were found")
+	return duration, matchers, comment, nil
 }
 
-func arrayDifference(a, b []string) []string {
-	mb := make(map[string]struct{}, len(b))
-	for _, x := range b {
-		mb[x] = struct{}{}
+// parseSilenceID returns the silence ID given as the command's first argument.
+func parseSilenceID(text string) (string, error) {
+	fields := strings.Fields(text)
+	if len(fields) != 2 {
+		return "", errors.New("expected exactly one silence ID")
 	}
-	var diff []string
-	for _, x := range a {
-		if _, found := mb[x]; !found {
-			diff = append(diff, x)
-		}
+	return fields[1], nil
+}
+
+// senderName returns the best human readable name of a Telegram user.
+func senderName(u *telebot.User) string {
+	if u.Username != "" {
+		return u.Username
 	}
-	return diff
+	return strings.TrimSpace(u.FirstName + " " + u.LastName)
 }
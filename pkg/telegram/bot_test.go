@@ -0,0 +1,126 @@
+package telegram
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/alertmanager/template"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSilenceCommand(t *testing.T) {
+	duration, matchers, comment, err := parseSilenceCommand(`/silence_add 2h alertname="NodeDown" instance=~"db-.*" planned maintenance`)
+	assert.Nil(t, err)
+	assert.Equal(t, 2*time.Hour, duration)
+	assert.Equal(t, 2, len(matchers))
+	assert.Equal(t, "alertname", matchers[0].Name)
+	assert.False(t, matchers[0].IsRegex)
+	assert.Equal(t, "db-.*", matchers[1].Value)
+	assert.True(t, matchers[1].IsRegex)
+	assert.Equal(t, "planned maintenance", comment)
+
+	_, _, comment, err = parseSilenceCommand(`/silence_add@alertbot 30m alertname="NodeDown"`)
+	assert.Nil(t, err)
+	assert.Equal(t, "", comment)
+
+	_, _, _, err = parseSilenceCommand(`/silence_add 2h no matchers`)
+	assert.NotNil(t, err)
+
+	_, _, _, err = parseSilenceCommand(`/silence_add forever alertname="NodeDown"`)
+	assert.NotNil(t, err)
+
+	_, _, _, err = parseSilenceCommand(`/silence_add 1h instance=~"(db"`)
+	assert.NotNil(t, err)
+
+	_, matchers, comment, err = parseSilenceCommand(`/silence_add 1h alertname="NodeDown" severity!="info" env!~"dev|test"`)
+	assert.Nil(t, err)
+	assert.Equal(t, "", comment)
+	assert.Equal(t, `alertname="NodeDown"`, matchers[0].String())
+	assert.Equal(t, `severity!="info"`, matchers[1].String())
+	assert.Equal(t, `env!~"dev|test"`, matchers[2].String())
+
+	// A mistyped matcher must not end up in the comment, making the silence broader
+	_, _, _, err = parseSilenceCommand(`/silence_add 1h alertname="NodeDown" severity="info`)
+	assert.NotNil(t, err)
+	_, _, _, err = parseSilenceCommand(`/silence_add 1h alertname="NodeDown" planned maintenance severity="info"`)
+	assert.NotNil(t, err)
+}
+
+func TestParseSilenceID(t *testing.T) {
+	id, err := parseSilenceID("/silence_del 5a3a0a3c-6b7e-4d7a-a3e5-fa2fd0dc6b80")
+	assert.Nil(t, err)
+	assert.Equal(t, "5a3a0a3c-6b7e-4d7a-a3e5-fa2fd0dc6b80", id)
+
+	_, err = parseSilenceID("/silence")
+	assert.NotNil(t, err)
+}
+
+func TestAlertMatchers(t *testing.T) {
+	alert := template.Alert{Labels: template.KV{"alertname": "NodeDown", "instance": "db-1"}}
+
+	matchers := alertMatchers(alert)
+	assert.Equal(t, 2, len(matchers))
+	assert.Equal(t, "alertname", matchers[0].Name)
+	assert.Equal(t, "NodeDown", matchers[0].Value)
+	assert.Equal(t, "instance", matchers[1].Name)
+	assert.False(t, matchers[1].IsRegex)
+}
+
+func TestAlertMessageText(t *testing.T) {
+	am := AlertMessage{Text: "<b>FIRING</b>"}
+	assert.Equal(t, "<b>FIRING</b>", alertMessageText(am))
+
+	am.AckedBy = "<admin>"
+	assert.Equal(t, "<b>FIRING</b>\n✅ <b>Acknowledged by:</b> &lt;admin&gt;", alertMessageText(am))
+
+	am.SilencedBy = "oncall"
+	assert.Equal(t, "<b>FIRING</b>\n✅ <b>Acknowledged by:</b> &lt;admin&gt;\n🔕 <b>Silenced by:</b> oncall", alertMessageText(am))
+}
+
+func TestAlertKeyboard(t *testing.T) {
+	assert.Equal(t, len(silenceDurations)+1, len(alertKeyboard(true, true).InlineKeyboard[0]))
+	assert.Equal(t, len(silenceDurations), len(alertKeyboard(false, true).InlineKeyboard[0]))
+	assert.Equal(t, callbackAck, alertKeyboard(true, false).InlineKeyboard[0][0].Unique)
+	assert.Nil(t, alertKeyboard(false, false))
+}
+
+func TestAlertsPageKeyboard(t *testing.T) {
+	keyboard := alertsPageKeyboard(0, 1)
+	assert.Equal(t, 1, len(keyboard.InlineKeyboard[0]))
+	assert.Equal(t, "1/1", keyboard.InlineKeyboard[0][0].Text)
+
+	keyboard = alertsPageKeyboard(1, 3)
+	assert.Equal(t, 3, len(keyboard.InlineKeyboard[0]))
+	assert.Equal(t, "0", keyboard.InlineKeyboard[0][0].Data)
+	assert.Equal(t, "2/3", keyboard.InlineKeyboard[0][1].Text)
+	assert.Equal(t, "2", keyboard.InlineKeyboard[0][2].Data)
+
+	keyboard = alertsPageKeyboard(2, 3)
+	assert.Equal(t, 2, len(keyboard.InlineKeyboard[0]))
+	assert.Equal(t, "« Prev", keyboard.InlineKeyboard[0][0].Text)
+}
+
+func TestParseMuteCommand(t *testing.T) {
+	matchers, duration, err := parseMuteCommand(`environment="staging" for 2h`)
+	assert.Nil(t, err)
+	assert.Equal(t, `environment="staging"`, matchers.String())
+	assert.Equal(t, 2*time.Hour, duration)
+
+	matchers, duration, err = parseMuteCommand(`environment=~"staging|dev" project!=shop for 30m`)
+	assert.Nil(t, err)
+	assert.Equal(t, `environment=~"staging|dev" project!="shop"`, matchers.String())
+	assert.Equal(t, 30*time.Minute, duration)
+
+	_, duration, err = parseMuteCommand(`environment="staging"`)
+	assert.Nil(t, err)
+	assert.Equal(t, time.Duration(0), duration)
+
+	_, _, err = parseMuteCommand(`environment="staging" for ever`)
+	assert.NotNil(t, err)
+
+	_, _, err = parseMuteCommand(`environment="staging" project: shop`)
+	assert.NotNil(t, err)
+
+	_, _, err = parseMuteCommand(`for 2h`)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,183 @@
+package telegram
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/docker/libkv/store"
+	"github.com/go-kit/kit/log/level"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+// apiError is a failed request's response, including the parameters telebot drops
+type apiError struct {
+	Code        int    `json:"error_code"`
+	Description string `json:"description"`
+	Parameters  struct {
+		MigrateToChatID int64 `json:"migrate_to_chat_id"`
+		RetryAfter      int   `json:"retry_after"`
+	} `json:"parameters"`
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("telegram: %s (%d)", e.Description, e.Code)
+}
+
+// sendMessage sends the text like telebot's Send does, but decodes the response itself.
+// telebot doesn't recognize errors carrying parameters and reports them without,
+// so the ID of the supergroup a group was upgraded to would be lost.
+func (b *Bot) sendMessage(chat *telebot.Chat, text string, options *telebot.SendOptions) (*telebot.Message, error) {
+	params := map[string]string{
+		"chat_id": strconv.FormatInt(chat.ID, 10),
+		"text":    text,
+	}
+	if options != nil {
+		if options.ParseMode != telebot.ModeDefault {
+			params["parse_mode"] = options.ParseMode
+		}
+		if options.DisableNotification {
+			params["disable_notification"] = "true"
+		}
+		if options.ReplyMarkup != nil {
+			markup, err := json.Marshal(callbackMarkup(options.ReplyMarkup))
+			if err != nil {
+				return nil, err
+			}
+			params["reply_markup"] = string(markup)
+		}
+	}
+
+	data, err := b.telegram.Raw("sendMessage", params)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp struct {
+		OK     bool             `json:"ok"`
+		Result *telebot.Message `json:"result"`
+		apiError
+	}
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %v", err)
+	}
+	if !resp.OK {
+		return nil, &resp.apiError
+	}
+	return resp.Result, nil
+}
+
+// callbackMarkup copies the markup with its buttons' data prefixed the way telebot routes callbacks,
+// without changing the markup, as the message might be sent again.
+func callbackMarkup(markup *telebot.ReplyMarkup) *telebot.ReplyMarkup {
+	m := *markup
+	m.InlineKeyboard = make([][]telebot.InlineButton, len(markup.InlineKeyboard))
+	for i, row := range markup.InlineKeyboard {
+		m.InlineKeyboard[i] = make([]telebot.InlineButton, len(row))
+		for j, button := range row {
+			if button.Unique != "" && button.Data == "" {
+				button.Data = "\f" + button.Unique
+			} else if button.Unique != "" {
+				button.Data = "\f" + button.Unique + "|" + button.Data
+			}
+			m.InlineKeyboard[i][j] = button
+		}
+	}
+	return &m
+}
+
+// migrated returns whether sending failed because the group was upgraded to a supergroup,
+// and the supergroup's ID if Telegram's error contains it.
+func migrated(err error) (int64, bool) {
+	if apiErr, ok := err.(*apiError); ok && apiErr.Parameters.MigrateToChatID != 0 {
+		return apiErr.Parameters.MigrateToChatID, true
+	}
+	return 0, strings.Contains(err.Error(), "upgraded to a supergroup")
+}
+
+// chatGone returns whether sending failed because the bot can't write to the chat anymore
+func chatGone(err error) bool {
+	msg := err.Error()
+	for _, s := range []string{
+		"bot was blocked by the user",
+		"bot was kicked",
+		"bot is not a member",
+		"user is deactivated",
+		"chat not found",
+	} {
+		if strings.Contains(msg, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// handleMigration moves the chat to its new ID once Telegram upgraded the group to a supergroup
+func (b *Bot) handleMigration(from, to int64) {
+	if err := b.chats.MigrateChat(from, to); err != nil {
+		level.Warn(b.logger).Log("msg", "failed to migrate chat to supergroup", "err", err, "from", from, "to", to)
+		return
+	}
+	level.Info(b.logger).Log("msg", "migrated chat to supergroup", "from", from, "to", to)
+}
+
+// handleSendError handles the failures telling that the chat moved or can't be written to anymore.
+// Migrated chats are moved to their new ID, chats the bot was blocked or kicked from are unsubscribed.
+func (b *Bot) handleSendError(chat *telebot.Chat, err error) {
+	if to, ok := migrated(err); ok {
+		if to == 0 {
+			level.Warn(b.logger).Log("msg", "chat was upgraded to a supergroup, waiting for its migration message", "chat_id", chat.ID)
+			return
+		}
+		b.handleMigration(chat.ID, to)
+		return
+	}
+
+	if !chatGone(err) {
+		return
+	}
+
+	if err := b.chats.RemoveChat(chat); err != nil {
+		// the chat's other messages failed the same way and it was unsubscribed already
+		if err == store.ErrKeyNotFound {
+			return
+		}
+		level.Warn(b.logger).Log("msg", "failed to unsubscribe chat the bot can't write to", "err", err, "chat_id", chat.ID)
+		return
+	}
+	level.Info(b.logger).Log("msg", "unsubscribed chat the bot can't write to", "err", err, "chat_id", chat.ID)
+
+	b.notifyAdmins(fmt.Sprintf("Chat %s was unsubscribed, as the bot can't write to it anymore: %v", chatName(chat), err))
+}
+
+// notifyAdmins queues sending the text to all configured admins
+func (b *Bot) notifyAdmins(text string) {
+	b.mu.RLock()
+	admins := append([]int(nil), b.admins...)
+	b.mu.RUnlock()
+
+	for _, id := range admins {
+		id := id
+		send := func() (*telebot.Message, error) {
+			return b.telegram.Send(&telebot.Chat{ID: int64(id)}, text)
+		}
+		b.queue.Enqueue(int64(id), send, func(_ *telebot.Message, err error) {
+			if err != nil {
+				level.Warn(b.logger).Log("msg", "failed to notify admin", "err", err, "admin_id", id)
+			}
+		})
+	}
+}
+
+// chatName describes the chat by its title or username and its ID
+func chatName(chat *telebot.Chat) string {
+	name := chat.Title
+	if name == "" && chat.Username != "" {
+		name = "@" + chat.Username
+	}
+	if name == "" {
+		return strconv.FormatInt(chat.ID, 10)
+	}
+	return fmt.Sprintf("%s (%d)", name, chat.ID)
+}
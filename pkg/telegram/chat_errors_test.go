@@ -0,0 +1,85 @@
+package telegram
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+func TestChatErrors(t *testing.T) {
+	id, ok := migrated(errors.New("api error: Bad Request: group chat was upgraded to a supergroup chat"))
+	assert.True(t, ok)
+	assert.Equal(t, int64(0), id)
+
+	_, ok = migrated(errors.New("telegram: Forbidden: bot was blocked by the user (403)"))
+	assert.False(t, ok)
+
+	assert.True(t, chatGone(errors.New("telegram: Forbidden: bot was blocked by the user (403)")))
+	assert.True(t, chatGone(errors.New("telegram: Forbidden: bot was kicked from the supergroup chat (403)")))
+	assert.False(t, chatGone(errors.New("telegram: Too Many Requests: retry after 5 (429)")))
+}
+
+func TestSendMessage(t *testing.T) {
+	var params map[string]string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/bot123:abc/getMe":
+			w.Write([]byte(`{"ok":true,"result":{"id":1,"is_bot":true,"first_name":"bot","username":"bot"}}`))
+		case "/bot123:abc/sendMessage":
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(&params))
+			switch params["chat_id"] {
+			case "-123":
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(`{"ok":false,"error_code":400,"description":"Bad Request: group chat was upgraded to a supergroup chat","parameters":{"migrate_to_chat_id":-1001234567890}}`))
+			case "-456":
+				w.WriteHeader(http.StatusTooManyRequests)
+				w.Write([]byte(`{"ok":false,"error_code":429,"description":"Too Many Requests: retry after 7","parameters":{"retry_after":7}}`))
+			default:
+				w.Write([]byte(`{"ok":true,"result":{"message_id":5,"chat":{"id":-1001234567890,"type":"supergroup"},"text":"firing"}}`))
+			}
+		}
+	}))
+	defer srv.Close()
+
+	tb, err := telebot.NewBot(telebot.Settings{URL: srv.URL, Token: "123:abc"})
+	assert.NoError(t, err)
+	b := &Bot{logger: log.NewNopLogger(), telegram: tb, chats: bot.chats}
+
+	chat := &telebot.Chat{ID: -123, Type: telebot.ChatGroup}
+	assert.NoError(t, b.chats.AddChat(chat))
+
+	_, err = b.sendMessage(chat, "firing", &telebot.SendOptions{ParseMode: telebot.ModeHTML, ReplyMarkup: alertKeyboard(true, true)})
+	assert.EqualError(t, err, "telegram: Bad Request: group chat was upgraded to a supergroup chat (400)")
+	assert.Equal(t, telebot.ModeHTML, params["parse_mode"])
+	var markup telebot.ReplyMarkup
+	assert.NoError(t, json.Unmarshal([]byte(params["reply_markup"]), &markup))
+	assert.Equal(t, "\f"+callbackAck, markup.InlineKeyboard[0][len(silenceDurations)].Data)
+
+	id, ok := migrated(err)
+	assert.True(t, ok)
+	assert.Equal(t, int64(-1001234567890), id)
+
+	b.handleSendError(chat, err)
+	_, err = b.chats.GetChatInfo(chat)
+	assert.Error(t, err)
+	chatInfo, err := b.chats.GetChatInfo(&telebot.Chat{ID: -1001234567890})
+	assert.NoError(t, err)
+	assert.Equal(t, telebot.ChatSuperGroup, chatInfo.Chat.Type)
+	assert.NoError(t, b.chats.RemoveChat(chatInfo.Chat))
+
+	_, err = b.sendMessage(&telebot.Chat{ID: -456}, "firing", nil)
+	retry, ok := retryAfter(err)
+	assert.True(t, ok)
+	assert.Equal(t, 7e9, float64(retry))
+
+	msg, err := b.sendMessage(&telebot.Chat{ID: -1001234567890}, "firing", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 5, msg.ID)
+	assert.Equal(t, "firing", params["text"])
+}
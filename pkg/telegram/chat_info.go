@@ -1,60 +1,189 @@
 package telegram
 
 import (
+	"encoding/json"
+	"fmt"
 	"gopkg.in/tucnak/telebot.v2"
+	"regexp"
 	"strings"
+	"time"
+
+	"github.com/prometheus/alertmanager/template"
 )
 
+// Mute hides the alerts matching all of its matchers from a chat
+type Mute struct {
+	Matchers Matchers
+	// Until is when the mute is lifted again, muted forever if it is zero
+	Until time.Time
+}
+
+func (m Mute) String() string {
+	if m.Until.IsZero() {
+		return m.Matchers.String()
+	}
+	return fmt.Sprintf("%s (until %s)", m.Matchers, m.Until.UTC().Format("2006-01-02 15:04 MST"))
+}
+
 type ChatInfo struct {
 	Chat				*telebot.Chat
-	AlertEnvironments	[]string
-	AlertProjects		[]string
-	MutedEnvironments	[]string
-	MutedProjects		[]string
+	// Mutes hide the alerts matching any of them, even if the chat is subscribed to them
+	Mutes				[]Mute
+	// Subscriptions route alerts to the chat if any of them match, all alerts without subscriptions
+	Subscriptions		[]Matchers
+	// Template alerts are rendered with, empty for telegram.default
+	Template			string
+	// Timezone is the IANA name of the chat's timezone, empty for UTC
+	Timezone			string
+	// QuietHours during which non-critical alerts don't notify the chat, nil if there are none
+	QuietHours			*QuietHours
+	// Mode alerts are delivered in, empty to get them right away or digest
+	Mode				string
+	// DigestInterval is how often chats in digest mode get a summary of their alerts
+	DigestInterval			time.Duration
+	// LastDigest is when the chat got its last digest
+	LastDigest			time.Time
+}
+
+// Location returns the chat's timezone, UTC if it has none or it is unknown
+func (ch *ChatInfo) Location() *time.Location {
+	if ch.Timezone == "" {
+		return time.UTC
+	}
+	loc, err := time.LoadLocation(ch.Timezone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
 }
 
-func (ch *ChatInfo) UnmuteEnvironment(env string, allEnvs []string) {
-	var index int
-	for i, value := range ch.MutedEnvironments {
-		if 0 == strings.Compare(value, env) {
-			index = i
-			break
+// Quiet returns whether the chat is within its quiet hours at the given time
+func (ch *ChatInfo) Quiet(now time.Time) bool {
+	return ch.QuietHours != nil && ch.QuietHours.Active(now.In(ch.Location()))
+}
+
+// Subscribed returns whether alerts with these labels are routed to the chat
+func (ch *ChatInfo) Subscribed(labels template.KV) bool {
+	if len(ch.Subscriptions) == 0 {
+		return true
+	}
+	for _, matchers := range ch.Subscriptions {
+		if matchers.Matches(labels) {
+			return true
 		}
 	}
-	ch.MutedEnvironments = append(ch.MutedEnvironments[:index], ch.MutedEnvironments[index+1:]...)
-	ch.AlertEnvironments = arrayDifference(allEnvs, ch.MutedEnvironments)
+	return false
 }
 
-func (ch *ChatInfo) UnmuteProject(pr string, allPrs []string) {
-	var index int
-	for i, value := range ch.MutedProjects {
-		if 0 == strings.Compare(value, pr) {
-			index = i
-			break
+// Subscribe adds the matchers as a new subscription unless the chat has it already
+func (ch *ChatInfo) Subscribe(matchers Matchers) {
+	for _, s := range ch.Subscriptions {
+		if s.String() == matchers.String() {
+			return
 		}
 	}
-	ch.MutedProjects = append(ch.MutedProjects[:index], ch.MutedProjects[index+1:]...)
-	ch.AlertProjects = arrayDifference(allPrs, ch.MutedProjects)
+	ch.Subscriptions = append(ch.Subscriptions, matchers)
 }
 
-func (ch *ChatInfo) MuteEnvironments(envsToMute []string, allEnvs []string) {
-	ch.MutedEnvironments = getUniqueStrings(append(ch.MutedEnvironments, envsToMute...))
-	ch.AlertEnvironments = arrayDifference(allEnvs, ch.MutedEnvironments)
+// Unsubscribe removes the subscription at the given index, starting at 1
+func (ch *ChatInfo) Unsubscribe(index int) error {
+	if index < 1 || index > len(ch.Subscriptions) {
+		return fmt.Errorf("there is no subscription %d", index)
+	}
+	ch.Subscriptions = append(ch.Subscriptions[:index-1], ch.Subscriptions[index:]...)
+	return nil
 }
 
-func (ch *ChatInfo) MuteProjects(prsToMute []string, allPrs []string) {
-	ch.MutedProjects = getUniqueStrings(append(ch.MutedProjects, prsToMute...))
-	ch.AlertProjects = arrayDifference(allPrs, ch.MutedProjects)
+// Muted returns whether alerts with these labels are hidden from the chat by a mute
+func (ch *ChatInfo) Muted(labels template.KV) bool {
+	for _, m := range ch.Mutes {
+		if m.Matchers.Matches(labels) {
+			return true
+		}
+	}
+	return false
 }
 
-func getUniqueStrings(values []string) []string {
-	uniqueSet := make(map[string]bool, len(values))
-	for _, x := range values {
-		uniqueSet[x] = true
+// Mute hides the alerts matching the matchers until the given time, forever if it is zero.
+// Muting the same matchers again only changes until when they're muted.
+func (ch *ChatInfo) Mute(matchers Matchers, until time.Time) {
+	for i, m := range ch.Mutes {
+		if m.Matchers.String() == matchers.String() {
+			ch.Mutes[i].Until = until
+			return
+		}
 	}
-	uniqueValues := make([]string, 0, len(uniqueSet))
-	for x := range uniqueSet {
-		uniqueValues = append(uniqueValues, x)
+	ch.Mutes = append(ch.Mutes, Mute{Matchers: matchers, Until: until})
+}
+
+// Unmute removes the mute at the given index, starting at 1
+func (ch *ChatInfo) Unmute(index int) error {
+	if index < 1 || index > len(ch.Mutes) {
+		return fmt.Errorf("there is no mute %d", index)
 	}
-	return uniqueValues
-}
\ No newline at end of file
+	ch.Mutes = append(ch.Mutes[:index-1], ch.Mutes[index:]...)
+	return nil
+}
+
+// ExpireMutes removes the mutes that expired by now and returns them
+func (ch *ChatInfo) ExpireMutes(now time.Time) []Mute {
+	var active, lapsed []Mute
+	for _, m := range ch.Mutes {
+		if !m.Until.IsZero() && !m.Until.After(now) {
+			lapsed = append(lapsed, m)
+			continue
+		}
+		active = append(active, m)
+	}
+	ch.Mutes = active
+	return lapsed
+}
+
+// UnmarshalJSON reads chat infos and migrates the environments and projects
+// chats muted before mutes were label matchers to mutes of the environment and project labels.
+func (ch *ChatInfo) UnmarshalJSON(data []byte) error {
+	type chatInfo ChatInfo
+	var legacy struct {
+		chatInfo
+		AlertEnvironments     []string
+		AlertProjects         []string
+		MutedEnvironments     []string
+		MutedProjects         []string
+		EnvironmentMutesUntil map[string]time.Time
+		ProjectMutesUntil     map[string]time.Time
+	}
+	if err := json.Unmarshal(data, &legacy); err != nil {
+		return err
+	}
+
+	*ch = ChatInfo(legacy.chatInfo)
+	ch.Mutes = append(ch.Mutes, legacyMutes("environment", legacy.AlertEnvironments, legacy.MutedEnvironments, legacy.EnvironmentMutesUntil)...)
+	ch.Mutes = append(ch.Mutes, legacyMutes("project", legacy.AlertProjects, legacy.MutedProjects, legacy.ProjectMutesUntil)...)
+	return nil
+}
+
+// legacyMutes returns mutes of the label for the muted values.
+// "other" stood for all values but the known ones, the ones that were either muted or not.
+func legacyMutes(label string, alerted, muted []string, until map[string]time.Time) []Mute {
+	var known []string
+	for _, value := range append(append([]string{}, alerted...), muted...) {
+		if value != "other" && !contains(known, regexp.QuoteMeta(value)) {
+			known = append(known, regexp.QuoteMeta(value))
+		}
+	}
+
+	var mutes []Mute
+	for _, value := range muted {
+		matcher, err := NewMatcher(label, MatchEqual, value)
+		if value == "other" && len(known) == 0 {
+			matcher, err = NewMatcher(label, MatchRegexp, ".*")
+		} else if value == "other" {
+			matcher, err = NewMatcher(label, MatchNotRegexp, strings.Join(known, "|"))
+		}
+		if err != nil {
+			continue
+		}
+		mutes = append(mutes, Mute{Matchers: Matchers{matcher}, Until: until[value]})
+	}
+	return mutes
+}
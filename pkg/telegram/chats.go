@@ -4,28 +4,57 @@ import (
 	"encoding/json"
 	"fmt"
 	"gopkg.in/tucnak/telebot.v2"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/docker/libkv/store"
+	"github.com/prometheus/alertmanager/template"
 )
 
 const telegramChatsDirectory = "telegram/chats"
 const telegramMessagesDirectory = "telegram/messages"
+const telegramAlertMessagesDirectory = "telegram/alert_messages"
+const telegramAlertFingerprintsDirectory = "telegram/alert_fingerprints"
+const telegramAlertsFiltersDirectory = "telegram/alerts_filters"
+const telegramRoutesDirectory = "telegram/routes"
+const telegramHeldAlertsDirectory = "telegram/held_alerts"
+const telegramRolesDirectory = "telegram/roles"
+const telegramLeaderKey = "telegram/leader"
 
 // ChatStore writes the users to a libkv store backend
 type ChatStore struct {
-	kv store.Store
+	kv        store.Store
+	namespace string
 }
 
+// ChatStoreOption passed to NewChatStore to change the default instance
+type ChatStoreOption func(s *ChatStore)
+
 // NewChatStore stores telegram chats in the provided kv backend
-func NewChatStore(kv store.Store) (*ChatStore, error) {
-	return &ChatStore{kv: kv}, nil
+func NewChatStore(kv store.Store, opts ...ChatStoreOption) (*ChatStore, error) {
+	s := &ChatStore{kv: kv}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s, nil
+}
+
+// WithNamespace keeps the chats apart from those of other bots sharing the kv backend
+func WithNamespace(namespace string) ChatStoreOption {
+	return func(s *ChatStore) {
+		if namespace != "" {
+			s.namespace = namespace + "/"
+		}
+	}
 }
 
 // List all chats saved in the kv backend
 func (s *ChatStore) List() ([]ChatInfo, error) {
-	kvPairs, err := s.kv.List(telegramChatsDirectory)
+	kvPairs, err := s.kv.List(s.namespace+telegramChatsDirectory)
+	if err == store.ErrKeyNotFound {
+		return nil, nil
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -42,14 +71,13 @@ func (s *ChatStore) List() ([]ChatInfo, error) {
 	return chatInfos, nil
 }
 
-func (s *ChatStore) AddChat(c *telebot.Chat, allEnvs []string, allPrs []string) error {
-	newChat := ChatInfo{Chat: c,  AlertEnvironments: allEnvs, AlertProjects: allPrs,
-		MutedEnvironments: []string{}, MutedProjects: []string{}}
+func (s *ChatStore) AddChat(c *telebot.Chat) error {
+	newChat := ChatInfo{Chat: c}
 	info, err := json.Marshal(newChat)
 	if err != nil {
 		return err
 	}
-	key := fmt.Sprintf("%s/%d", telegramChatsDirectory, c.ID)
+	key := s.chatKey(c)
 	return s.kv.Put(key, info, nil)
 }
 
@@ -63,11 +91,11 @@ func (s *ChatStore) AddMessage(m *telebot.Message) error {
 	if err != nil {
 		return nil
 	}
-	return s.kv.Put(telegramMessagesDirectory, info, nil)
+	return s.kv.Put(s.namespace+telegramMessagesDirectory, info, nil)
 }
 
 func (s *ChatStore) GetAllMessages() ([]telebot.Message, error) {
-	kvPair, err := s.kv.Get(telegramMessagesDirectory)
+	kvPair, err := s.kv.Get(s.namespace+telegramMessagesDirectory)
 	if err != nil {
 		if 0 == strings.Compare("Key not found in store", err.Error()) {
 			return []telebot.Message{}, nil
@@ -83,7 +111,7 @@ func (s *ChatStore) GetAllMessages() ([]telebot.Message, error) {
 }
 
 func (s *ChatStore) DeleteAllMessages() error {
-	return s.kv.Delete(telegramMessagesDirectory)
+	return s.kv.Delete(s.namespace+telegramMessagesDirectory)
 }
 
 func (s *ChatStore) GetMessagesForPeriodInMinutes(minutes float64) ([]telebot.Message, error) {
@@ -106,15 +134,107 @@ func (s *ChatStore) GetMessagesForPeriodInMinutes(minutes float64) ([]telebot.Me
 	if err != nil {
 		return nil, err
 	}
-	err = s.kv.Put(telegramMessagesDirectory, info, nil)
+	err = s.kv.Put(s.namespace+telegramMessagesDirectory, info, nil)
 	if err != nil {
 		return nil, err
 	}
 	return messagesToDelete, nil
 }
 
+// AddAlertMessage stores which alerts a sent message shows, to act on them via inline buttons
+func (s *ChatStore) AddAlertMessage(am AlertMessage) error {
+	info, err := json.Marshal(am)
+	if err != nil {
+		return err
+	}
+	return s.kv.Put(s.alertMessageKey(am.Message), info, nil)
+}
+
+// GetAlertMessage returns the alerts stored for a sent message
+func (s *ChatStore) GetAlertMessage(m *telebot.Message) (AlertMessage, error) {
+	kvPair, err := s.kv.Get(s.alertMessageKey(m))
+	if err != nil {
+		return AlertMessage{}, err
+	}
+
+	var am AlertMessage
+	if err = json.Unmarshal(kvPair.Value, &am); err != nil {
+		return AlertMessage{}, err
+	}
+	return am, nil
+}
+
+// RemoveAlertMessage forgets the alerts stored for a sent message
+// together with the fingerprints still pointing to it
+func (s *ChatStore) RemoveAlertMessage(m *telebot.Message) error {
+	am, err := s.GetAlertMessage(m)
+	if err == store.ErrKeyNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, alert := range am.Alerts {
+		fingerprint := alertFingerprint(alert)
+		msg, err := s.GetFingerprintMessage(m.Chat, fingerprint)
+		if err != nil || msg.ID != m.ID {
+			continue
+		}
+		if err := s.RemoveAlertFingerprint(m.Chat, fingerprint); err != nil {
+			return err
+		}
+	}
+
+	return s.kv.Delete(s.alertMessageKey(m))
+}
+
+// AddAlertFingerprint remembers the message a firing alert was last sent with
+func (s *ChatStore) AddAlertFingerprint(m *telebot.Message, fingerprint string) error {
+	info, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return s.kv.Put(s.alertFingerprintKey(m.Chat, fingerprint), info, nil)
+}
+
+// GetFingerprintMessage returns the message a firing alert was last sent with
+func (s *ChatStore) GetFingerprintMessage(c *telebot.Chat, fingerprint string) (*telebot.Message, error) {
+	kvPair, err := s.kv.Get(s.alertFingerprintKey(c, fingerprint))
+	if err != nil {
+		return nil, err
+	}
+
+	var m telebot.Message
+	if err = json.Unmarshal(kvPair.Value, &m); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// RemoveAlertFingerprint forgets the message a firing alert was last sent with
+func (s *ChatStore) RemoveAlertFingerprint(c *telebot.Chat, fingerprint string) error {
+	err := s.kv.Delete(s.alertFingerprintKey(c, fingerprint))
+	if err == store.ErrKeyNotFound {
+		return nil
+	}
+	return err
+}
+
+func (s *ChatStore) chatKey(c *telebot.Chat) string {
+	return fmt.Sprintf("%s/%d", s.namespace+telegramChatsDirectory, c.ID)
+}
+
+func (s *ChatStore) alertFingerprintKey(c *telebot.Chat, fingerprint string) string {
+	return fmt.Sprintf("%s/%d/%s", s.namespace+telegramAlertFingerprintsDirectory, c.ID, fingerprint)
+}
+
+func (s *ChatStore) alertMessageKey(m *telebot.Message) string {
+	return fmt.Sprintf("%s/%d/%d", s.namespace+telegramAlertMessagesDirectory, m.Chat.ID, m.ID)
+}
+
 func (s *ChatStore) GetChatInfo(c *telebot.Chat) (ChatInfo, error) {
-	key := fmt.Sprintf("%s/%d", telegramChatsDirectory, c.ID)
+	key := s.chatKey(c)
 	kvPairs, err := s.kv.Get(key)
 	if err != nil {
 		return ChatInfo{}, err
@@ -128,12 +248,87 @@ func (s *ChatStore) GetChatInfo(c *telebot.Chat) (ChatInfo, error) {
 }
 
 func (s *ChatStore) RemoveChat(c *telebot.Chat) error {
-	key := fmt.Sprintf("%s/%d", telegramChatsDirectory, c.ID)
+	key := s.chatKey(c)
 	return s.kv.Delete(key)
 }
 
-func (s *ChatStore) MuteEnvironments(c *telebot.Chat, envsToMute []string, allEnvs []string) error {
-	key := fmt.Sprintf("%s/%d", telegramChatsDirectory, c.ID)
+// AddAlertsFilter remembers the filter a message listing alerts was sent with, to navigate its pages
+func (s *ChatStore) AddAlertsFilter(m *telebot.Message, filter string) error {
+	info, err := json.Marshal(filter)
+	if err != nil {
+		return err
+	}
+	return s.kv.Put(s.alertsFilterKey(m), info, nil)
+}
+
+// GetAlertsFilter returns the filter a message listing alerts was sent with
+func (s *ChatStore) GetAlertsFilter(m *telebot.Message) (string, error) {
+	kvPair, err := s.kv.Get(s.alertsFilterKey(m))
+	if err != nil {
+		return "", err
+	}
+
+	var filter string
+	if err = json.Unmarshal(kvPair.Value, &filter); err != nil {
+		return "", err
+	}
+	return filter, nil
+}
+
+// RemoveAlertsFilter forgets the filter a message listing alerts was sent with
+func (s *ChatStore) RemoveAlertsFilter(m *telebot.Message) error {
+	err := s.kv.Delete(s.alertsFilterKey(m))
+	if err == store.ErrKeyNotFound {
+		return nil
+	}
+	return err
+}
+
+func (s *ChatStore) alertsFilterKey(m *telebot.Message) string {
+	return fmt.Sprintf("%s/%d/%d", s.namespace+telegramAlertsFiltersDirectory, m.Chat.ID, m.ID)
+}
+
+// Subscribe routes alerts matching all matchers to the chat
+func (s *ChatStore) Subscribe(c *telebot.Chat, matchers Matchers) error {
+	return s.updateChatInfo(c, func(chatInfo *ChatInfo) error {
+		chatInfo.Subscribe(matchers)
+		return nil
+	})
+}
+
+// Unsubscribe removes the chat's subscription at the given index, starting at 1
+func (s *ChatStore) Unsubscribe(c *telebot.Chat, index int) error {
+	return s.updateChatInfo(c, func(chatInfo *ChatInfo) error {
+		return chatInfo.Unsubscribe(index)
+	})
+}
+
+// Mute hides the alerts matching the matchers from the chat until the given time, forever if it is zero
+func (s *ChatStore) Mute(c *telebot.Chat, matchers Matchers, until time.Time) error {
+	return s.updateChatInfo(c, func(chatInfo *ChatInfo) error {
+		chatInfo.Mute(matchers, until)
+		return nil
+	})
+}
+
+// Unmute removes the chat's mute at the given index, starting at 1
+func (s *ChatStore) Unmute(c *telebot.Chat, index int) error {
+	return s.updateChatInfo(c, func(chatInfo *ChatInfo) error {
+		return chatInfo.Unmute(index)
+	})
+}
+
+// SetTemplate sets the name of the template the chat's alerts are rendered with
+func (s *ChatStore) SetTemplate(c *telebot.Chat, name string) error {
+	return s.updateChatInfo(c, func(chatInfo *ChatInfo) error {
+		chatInfo.Template = name
+		return nil
+	})
+}
+
+// updateChatInfo reads the chat's info, applies update and writes it back
+func (s *ChatStore) updateChatInfo(c *telebot.Chat, update func(*ChatInfo) error) error {
+	key := s.chatKey(c)
 	kvPairs, err := s.kv.Get(key)
 	if err != nil {
 		return err
@@ -143,7 +338,9 @@ func (s *ChatStore) MuteEnvironments(c *telebot.Chat, envsToMute []string, allEn
 	if err = json.Unmarshal(kvPairs.Value, &chatInfo); err != nil {
 		return err
 	}
-	chatInfo.MuteEnvironments(envsToMute, allEnvs)
+	if err = update(&chatInfo); err != nil {
+		return err
+	}
 	updated, err := json.Marshal(chatInfo)
 	if err != nil {
 		return err
@@ -151,87 +348,410 @@ func (s *ChatStore) MuteEnvironments(c *telebot.Chat, envsToMute []string, allEn
 	return s.kv.Put(key, updated, nil)
 }
 
-func (s *ChatStore) MuteProjects(c *telebot.Chat, prsToMute []string, allPrs []string) error {
-	key := fmt.Sprintf("%s/%d", telegramChatsDirectory, c.ID)
-	kvPairs, err := s.kv.Get(key)
+// SetTimezone sets the IANA name of the chat's timezone
+func (s *ChatStore) SetTimezone(c *telebot.Chat, timezone string) error {
+	return s.updateChatInfo(c, func(chatInfo *ChatInfo) error {
+		chatInfo.Timezone = timezone
+		return nil
+	})
+}
+
+// SetQuietHours sets the chat's quiet hours, nil removes them
+func (s *ChatStore) SetQuietHours(c *telebot.Chat, quietHours *QuietHours) error {
+	return s.updateChatInfo(c, func(chatInfo *ChatInfo) error {
+		chatInfo.QuietHours = quietHours
+		return nil
+	})
+}
+
+// SetMode sets how the chat gets its alerts, empty for right away or digest every interval
+func (s *ChatStore) SetMode(c *telebot.Chat, mode string, interval time.Duration) error {
+	return s.updateChatInfo(c, func(chatInfo *ChatInfo) error {
+		chatInfo.Mode = mode
+		chatInfo.DigestInterval = interval
+		return nil
+	})
+}
+
+// SetLastDigest remembers when the chat got its last digest
+func (s *ChatStore) SetLastDigest(c *telebot.Chat, t time.Time) error {
+	return s.updateChatInfo(c, func(chatInfo *ChatInfo) error {
+		chatInfo.LastDigest = t
+		return nil
+	})
+}
+
+// HoldAlerts keeps alerts held back during the chat's quiet hours or for its digest until they're sent as one batch
+func (s *ChatStore) HoldAlerts(c *telebot.Chat, data template.Data) error {
+	value, err := json.Marshal(data)
 	if err != nil {
 		return err
 	}
+	key := fmt.Sprintf("%s%020d", s.heldAlertsPrefix(c), time.Now().UnixNano())
+	return s.kv.Put(key, value, nil)
+}
 
-	var chatInfo *ChatInfo
-	if err = json.Unmarshal(kvPairs.Value, &chatInfo); err != nil {
-		return err
+// HeldAlerts returns all alerts held back for the chat, oldest first, and their keys to remove them once sent
+func (s *ChatStore) HeldAlerts(c *telebot.Chat) ([]template.Data, []string, error) {
+	kvPairs, err := s.kv.List(s.heldAlertsPrefix(c))
+	if err == store.ErrKeyNotFound {
+		return nil, nil, nil
 	}
-	chatInfo.MuteProjects(prsToMute, allPrs)
-	updated, err := json.Marshal(chatInfo)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
-	return s.kv.Put(key, updated, nil)
+
+	sort.Slice(kvPairs, func(i, j int) bool {
+		return kvPairs[i].Key < kvPairs[j].Key
+	})
+
+	var held []template.Data
+	var keys []string
+	for _, kv := range kvPairs {
+		var data template.Data
+		if err := json.Unmarshal(kv.Value, &data); err != nil {
+			return nil, nil, err
+		}
+		held = append(held, data)
+		keys = append(keys, kv.Key)
+	}
+	return held, keys, nil
 }
 
-func (s *ChatStore) UnmuteEnvironment(c *telebot.Chat, envToUnmute string, allEnvs []string) error {
-	key := fmt.Sprintf("%s/%d", telegramChatsDirectory, c.ID)
-	kvPairs, err := s.kv.Get(key)
+// RemoveHeldAlerts removes the held alerts by the keys returned with them
+func (s *ChatStore) RemoveHeldAlerts(keys []string) error {
+	for _, key := range keys {
+		if err := s.kv.Delete(key); err != nil && err != store.ErrKeyNotFound {
+			return err
+		}
+	}
+	return nil
+}
+
+// heldAlertsPrefix ends with a slash, so that listing chat 12 doesn't list chat 123 as well
+func (s *ChatStore) heldAlertsPrefix(c *telebot.Chat) string {
+	return fmt.Sprintf("%s%s/%d/", s.namespace, telegramHeldAlertsDirectory, c.ID)
+}
+
+// LeaderLock returns the lock the bot's replicas hold to be the leader
+func (s *ChatStore) LeaderLock(options *store.LockOptions) (store.Locker, error) {
+	return s.kv.NewLock(s.namespace+telegramLeaderKey, options)
+}
+
+// MigrateChat moves everything kept for the chat to its new ID,
+// after Telegram upgraded a group to a supergroup with another ID.
+// Chats that aren't subscribed or were migrated already are left alone.
+func (s *ChatStore) MigrateChat(from, to int64) error {
+	oldChat, newChat := &telebot.Chat{ID: from}, &telebot.Chat{ID: to}
+
+	kvPair, err := s.kv.Get(s.chatKey(oldChat))
+	if err == store.ErrKeyNotFound {
+		return nil
+	}
 	if err != nil {
 		return err
 	}
 
 	var chatInfo ChatInfo
-	if err = json.Unmarshal(kvPairs.Value, &chatInfo); err != nil {
+	if err := json.Unmarshal(kvPair.Value, &chatInfo); err != nil {
 		return err
 	}
-	chatInfo.UnmuteEnvironment(envToUnmute, allEnvs)
-	updated, err := json.Marshal(chatInfo)
+	chatInfo.Chat.ID = to
+	chatInfo.Chat.Type = telebot.ChatSuperGroup
+
+	value, err := json.Marshal(chatInfo)
 	if err != nil {
 		return err
 	}
-	return s.kv.Put(key, updated, nil)
+	if err := s.kv.Put(s.chatKey(newChat), value, nil); err != nil {
+		return err
+	}
+
+	routes, err := s.Routes()
+	if err != nil {
+		return err
+	}
+	for _, r := range routes {
+		if !r.RemoveChat(from) {
+			continue
+		}
+		r.AddChat(to)
+		if err := s.SetRouteChats(r.Name, r.ChatIDs); err != nil {
+			return err
+		}
+	}
+
+	if err := s.migrateHeldAlerts(oldChat, newChat); err != nil {
+		return err
+	}
+	if err := s.migrateRoles(from, to); err != nil {
+		return err
+	}
+
+	return s.kv.Delete(s.chatKey(oldChat))
 }
 
-func (s *ChatStore) UnmuteProject(c *telebot.Chat, prToUnmute string, allPrs []string) error {
-	key := fmt.Sprintf("%s/%d", telegramChatsDirectory, c.ID)
-	kvPairs, err := s.kv.Get(key)
+func (s *ChatStore) migrateHeldAlerts(from, to *telebot.Chat) error {
+	kvPairs, err := s.kv.List(s.heldAlertsPrefix(from))
+	if err == store.ErrKeyNotFound {
+		return nil
+	}
 	if err != nil {
 		return err
 	}
 
-	var chatInfo ChatInfo
-	if err = json.Unmarshal(kvPairs.Value, &chatInfo); err != nil {
+	for _, kv := range kvPairs {
+		key := s.heldAlertsPrefix(to) + kv.Key[strings.LastIndex(kv.Key, "/")+1:]
+		if err := s.kv.Put(key, kv.Value, nil); err != nil {
+			return err
+		}
+		if err := s.kv.Delete(kv.Key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *ChatStore) migrateRoles(from, to int64) error {
+	kvPairs, err := s.kv.List(s.namespace + telegramRolesDirectory)
+	if err == store.ErrKeyNotFound {
+		return nil
+	}
+	if err != nil {
 		return err
 	}
-	chatInfo.UnmuteProject(prToUnmute, allPrs)
-	updated, err := json.Marshal(chatInfo)
+
+	for _, kv := range kvPairs {
+		var roles UserRoles
+		if err := json.Unmarshal(kv.Value, &roles); err != nil {
+			return err
+		}
+		role, ok := roles.Chats[from]
+		if !ok {
+			continue
+		}
+		delete(roles.Chats, from)
+		roles.Chats[to] = role
+
+		value, err := json.Marshal(roles)
+		if err != nil {
+			return err
+		}
+		if err := s.kv.Put(kv.Key, value, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetUserRoles returns the roles granted to the user, none if there are none
+func (s *ChatStore) GetUserRoles(userID int) (UserRoles, error) {
+	kvPair, err := s.kv.Get(s.rolesKey(userID))
+	if err == store.ErrKeyNotFound {
+		return UserRoles{}, nil
+	}
+	if err != nil {
+		return UserRoles{}, err
+	}
+
+	var roles UserRoles
+	err = json.Unmarshal(kvPair.Value, &roles)
+	return roles, err
+}
+
+// GrantRole grants the role to the user in the chat, in all chats if chatID is 0
+func (s *ChatStore) GrantRole(userID int, chatID int64, role Role) error {
+	roles, err := s.GetUserRoles(userID)
 	if err != nil {
 		return err
 	}
-	return s.kv.Put(key, updated, nil)
+
+	if chatID == 0 {
+		roles.Role = role
+	} else {
+		if roles.Chats == nil {
+			roles.Chats = make(map[int64]Role)
+		}
+		roles.Chats[chatID] = role
+	}
+
+	return s.putUserRoles(userID, roles)
 }
 
-func (s *ChatStore) MutedEnvironments(c *telebot.Chat) ([]string, error) {
-	key := fmt.Sprintf("%s/%d", telegramChatsDirectory, c.ID)
-	kvPairs, err := s.kv.Get(key)
+// RevokeRole revokes the user's role in the chat, in all chats if chatID is 0.
+// Roles granted in single chats are kept when revoking the role in all chats.
+func (s *ChatStore) RevokeRole(userID int, chatID int64) error {
+	roles, err := s.GetUserRoles(userID)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	var chatInfo ChatInfo
-	if err = json.Unmarshal(kvPairs.Value, &chatInfo); err != nil {
-		return nil, err
+	if chatID == 0 {
+		if roles.Role == RoleNone {
+			return fmt.Errorf("user %d has no role in all chats", userID)
+		}
+		roles.Role = RoleNone
+	} else {
+		if _, ok := roles.Chats[chatID]; !ok {
+			return fmt.Errorf("user %d has no role in chat %d", userID, chatID)
+		}
+		delete(roles.Chats, chatID)
+	}
+
+	if roles.Role == RoleNone && len(roles.Chats) == 0 {
+		return s.kv.Delete(s.rolesKey(userID))
 	}
-	return chatInfo.MutedEnvironments, nil
+	return s.putUserRoles(userID, roles)
 }
 
-func (s *ChatStore) MutedProjects(c *telebot.Chat) ([]string, error) {
-	key := fmt.Sprintf("%s/%d", telegramChatsDirectory, c.ID)
-	kvPairs, err := s.kv.Get(key)
+func (s *ChatStore) putUserRoles(userID int, roles UserRoles) error {
+	value, err := json.Marshal(roles)
+	if err != nil {
+		return err
+	}
+	return s.kv.Put(s.rolesKey(userID), value, nil)
+}
+
+func (s *ChatStore) rolesKey(userID int) string {
+	return fmt.Sprintf("%s%s/%d", s.namespace, telegramRolesDirectory, userID)
+}
+
+// LapsedMutes are the mutes of a chat that expired
+type LapsedMutes struct {
+	Chat  *telebot.Chat
+	Mutes []Mute
+}
+
+// ExpireMutes removes the mutes of all chats that expired by now
+// and returns them for every chat that had any.
+func (s *ChatStore) ExpireMutes(now time.Time) ([]LapsedMutes, error) {
+	chats, err := s.List()
 	if err != nil {
 		return nil, err
 	}
 
-	var chatInfo ChatInfo
-	if err = json.Unmarshal(kvPairs.Value, &chatInfo); err != nil {
+	var lapsed []LapsedMutes
+	for _, chatInfo := range chats {
+		if len(chatInfo.ExpireMutes(now)) == 0 {
+			continue
+		}
+
+		l := LapsedMutes{Chat: chatInfo.Chat}
+		err := s.updateChatInfo(chatInfo.Chat, func(chatInfo *ChatInfo) error {
+			l.Mutes = chatInfo.ExpireMutes(now)
+			return nil
+		})
+		if err != nil {
+			return lapsed, err
+		}
+		if len(l.Mutes) > 0 {
+			lapsed = append(lapsed, l)
+		}
+	}
+
+	return lapsed, nil
+}
+
+// Routes returns all routes saved in the kv backend
+func (s *ChatStore) Routes() ([]Route, error) {
+	kvPairs, err := s.kv.List(s.namespace+telegramRoutesDirectory)
+	if err == store.ErrKeyNotFound {
+		return nil, nil
+	}
+	if err != nil {
 		return nil, err
 	}
-	return chatInfo.MutedProjects, nil
-}
\ No newline at end of file
+
+	var routes []Route
+	for _, kv := range kvPairs {
+		var route Route
+		if err := json.Unmarshal(kv.Value, &route); err != nil {
+			return nil, err
+		}
+		routes = append(routes, route)
+	}
+	return routes, nil
+}
+
+// GetRoute returns the route by its name, a route without chats if there is none
+func (s *ChatStore) GetRoute(name string) (Route, error) {
+	kvPair, err := s.kv.Get(s.routeKey(name))
+	if err == store.ErrKeyNotFound {
+		return Route{Name: name}, nil
+	}
+	if err != nil {
+		return Route{}, err
+	}
+
+	var route Route
+	if err = json.Unmarshal(kvPair.Value, &route); err != nil {
+		return Route{}, err
+	}
+	return route, nil
+}
+
+// HasRoute returns whether the route exists, it does as long as it has chats
+func (s *ChatStore) HasRoute(name string) (bool, error) {
+	route, err := s.GetRoute(name)
+	if err != nil {
+		return false, err
+	}
+	return len(route.ChatIDs) > 0, nil
+}
+
+// AddRouteChat adds the chat to the route, creating the route if necessary
+func (s *ChatStore) AddRouteChat(name string, c *telebot.Chat) error {
+	route, err := s.GetRoute(name)
+	if err != nil {
+		return err
+	}
+	route.AddChat(c.ID)
+
+	value, err := json.Marshal(route)
+	if err != nil {
+		return err
+	}
+	return s.kv.Put(s.routeKey(name), value, nil)
+}
+
+// RemoveRouteChat removes the chat from the route, removing the route once it has no chats left
+func (s *ChatStore) RemoveRouteChat(name string, c *telebot.Chat) error {
+	route, err := s.GetRoute(name)
+	if err != nil {
+		return err
+	}
+	if !route.RemoveChat(c.ID) {
+		return fmt.Errorf("this chat is not part of route %s", name)
+	}
+
+	if len(route.ChatIDs) == 0 {
+		return s.kv.Delete(s.routeKey(name))
+	}
+
+	value, err := json.Marshal(route)
+	if err != nil {
+		return err
+	}
+	return s.kv.Put(s.routeKey(name), value, nil)
+}
+
+// SetRouteChats replaces the chats of the route, removing the route if there are none
+func (s *ChatStore) SetRouteChats(name string, chatIDs []int64) error {
+	if len(chatIDs) == 0 {
+		err := s.kv.Delete(s.routeKey(name))
+		if err == store.ErrKeyNotFound {
+			return nil
+		}
+		return err
+	}
+
+	value, err := json.Marshal(Route{Name: name, ChatIDs: chatIDs})
+	if err != nil {
+		return err
+	}
+	return s.kv.Put(s.routeKey(name), value, nil)
+}
+
+func (s *ChatStore) routeKey(name string) string {
+	return fmt.Sprintf("%s/%s", s.namespace+telegramRoutesDirectory, name)
+}
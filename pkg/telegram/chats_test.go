@@ -1,11 +1,14 @@
 package telegram
 
 import (
+	"encoding/json"
 	"fmt"
 	"github.com/docker/libkv/store"
 	"github.com/docker/libkv/store/boltdb"
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/alertmanager/notify"
+	"github.com/prometheus/alertmanager/template"
 	"github.com/stretchr/testify/assert"
 	"gopkg.in/tucnak/telebot.v2"
 	"os"
@@ -43,71 +46,69 @@ func TestMain(m *testing.M) {
 	os.Exit(code)
 }
 
-func TestMutingEnvironment(t *testing.T) {
-	allEnvs := []string{"env1", "env2", "env3"}
-	allPrs := []string{"pr1", "pr2"}
-	chat := telebot.Chat{ID:123}
-	err := bot.chats.AddChat(&chat, allEnvs, allPrs)
-	assert.Nil(t, err)
+func TestMute(t *testing.T) {
+	chat := telebot.Chat{ID: 123}
+	assert.Nil(t, bot.chats.AddChat(&chat))
 
-	err = bot.chats.MuteEnvironments(&chat, []string{"env1"}, allEnvs)
+	staging, err := ParseMatchers(`environment="staging"`)
 	assert.Nil(t, err)
-
-	chatInfo, err := bot.chats.GetChatInfo(&chat)
+	shop, err := ParseMatchers(`project=~"shop|cart"`)
 	assert.Nil(t, err)
-	assert.True(t, len(chatInfo.AlertEnvironments) == 2)
-	assert.True(t, len(chatInfo.MutedEnvironments) == 1)
-}
 
-func TestMutingProjects(t *testing.T) {
-	allEnvs := []string{"env1", "env2", "env3"}
-	allPrs := []string{"pr1", "pr2"}
-	chat := telebot.Chat{ID:1233}
-	err := bot.chats.AddChat(&chat, allEnvs, allPrs)
-	assert.Nil(t, err)
-
-	err = bot.chats.MuteProjects(&chat, []string{"pr1"}, allPrs)
-	assert.Nil(t, err)
+	assert.Nil(t, bot.chats.Mute(&chat, staging, time.Time{}))
+	assert.Nil(t, bot.chats.Mute(&chat, shop, time.Time{}))
+	assert.Nil(t, bot.chats.Mute(&chat, staging, time.Time{}))
 
 	chatInfo, err := bot.chats.GetChatInfo(&chat)
 	assert.Nil(t, err)
-	assert.True(t, len(chatInfo.AlertProjects) == 1)
-	assert.True(t, len(chatInfo.MutedProjects) == 1)
-}
+	assert.Equal(t, 2, len(chatInfo.Mutes))
+	assert.True(t, chatInfo.Muted(template.KV{"environment": "staging"}))
+	assert.True(t, chatInfo.Muted(template.KV{"environment": "production", "project": "cart"}))
+	assert.False(t, chatInfo.Muted(template.KV{"environment": "production", "project": "db"}))
 
-func TestUnmuteEnvironment(t *testing.T) {
-	allEnvs := []string{"env1", "env2", "env3"}
-	allPrs := []string{"pr1", "pr2"}
-	chat := telebot.Chat{ID:134}
-	err := bot.chats.AddChat(&chat, allEnvs, allPrs)
-	assert.Nil(t, err)
+	assert.Nil(t, bot.chats.Unmute(&chat, 1))
+	assert.NotNil(t, bot.chats.Unmute(&chat, 2))
 
-	err = bot.chats.MuteEnvironments(&chat, []string{"env1", "env2"}, allEnvs)
+	chatInfo, err = bot.chats.GetChatInfo(&chat)
 	assert.Nil(t, err)
+	assert.Equal(t, 1, len(chatInfo.Mutes))
+	assert.False(t, chatInfo.Muted(template.KV{"environment": "staging"}))
+}
 
-	chatInfo, err := bot.chats.GetChatInfo(&chat)
-	assert.Nil(t, err)
-	assert.True(t, len(chatInfo.AlertEnvironments) == 1)
-	assert.True(t, len(chatInfo.MutedEnvironments) == 2)
+func TestMigrateLegacyMutes(t *testing.T) {
+	legacy := `{"Chat":{"id":125},"AlertEnvironments":["production"],"AlertProjects":["other"],` +
+		`"MutedEnvironments":["staging","other"],"MutedProjects":["shop"],` +
+		`"EnvironmentMutesUntil":{"staging":"2026-10-16T15:04:00Z"}}`
 
-	err = bot.chats.UnmuteEnvironment(&chat, "env1", allEnvs)
-	assert.Nil(t, err)
+	var chatInfo ChatInfo
+	assert.Nil(t, json.Unmarshal([]byte(legacy), &chatInfo))
+	assert.Equal(t, int64(125), chatInfo.Chat.ID)
+	assert.Equal(t, 3, len(chatInfo.Mutes))
+	assert.Equal(t, `environment="staging"`, chatInfo.Mutes[0].Matchers.String())
+	assert.Equal(t, time.Date(2026, 10, 16, 15, 4, 0, 0, time.UTC), chatInfo.Mutes[0].Until.UTC())
+	assert.Equal(t, `environment!~"production|staging"`, chatInfo.Mutes[1].Matchers.String())
+	assert.True(t, chatInfo.Mutes[1].Until.IsZero())
+	assert.Equal(t, `project="shop"`, chatInfo.Mutes[2].Matchers.String())
 
-	chatInfo, err = bot.chats.GetChatInfo(&chat)
+	assert.True(t, chatInfo.Muted(template.KV{"environment": "dev", "project": "db"}))
+	assert.True(t, chatInfo.Muted(template.KV{"environment": "production", "project": "shop"}))
+	assert.False(t, chatInfo.Muted(template.KV{"environment": "production", "project": "db"}))
+
+	// once saved again the mutes aren't migrated twice
+	data, err := json.Marshal(chatInfo)
 	assert.Nil(t, err)
-	assert.True(t, len(chatInfo.MutedEnvironments) == 1)
-	assert.True(t, len(chatInfo.AlertEnvironments) == 2)
+	var saved ChatInfo
+	assert.Nil(t, json.Unmarshal(data, &saved))
+	assert.Equal(t, 3, len(saved.Mutes))
 }
 
 func TestGettingChatLists(t *testing.T) {
-	allEnvs := []string{"env1", "env2", "env3"}
-	allPrs := []string{"pr1", "pr2"}
 	chat := telebot.Chat{ID:134}
-	err := bot.chats.AddChat(&chat, allEnvs, allPrs)
+	err := bot.chats.AddChat(&chat)
 	assert.Nil(t, err)
 
 	chat = telebot.Chat{ID:32}
-	err = bot.chats.AddChat(&chat, allEnvs, allPrs)
+	err = bot.chats.AddChat(&chat)
 	assert.Nil(t, err)
 
 	chats, err := bot.chats.List()
@@ -155,4 +156,265 @@ func TestGettingMessagesForPeriod(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Equal(t, 1, len(msgsSaved))
 
-}
\ No newline at end of file
+}
+func TestAlertFingerprints(t *testing.T) {
+	chat := telebot.Chat{ID: 4242}
+	msg := telebot.Message{ID: 7, Chat: &chat}
+	alert := template.Alert{Status: "firing", Labels: template.KV{"alertname": "NodeDown"}}
+	fingerprint := alertFingerprint(alert)
+
+	err := bot.chats.AddAlertMessage(AlertMessage{Message: &msg, Text: "NodeDown", Alerts: template.Alerts{alert}})
+	assert.Nil(t, err)
+	err = bot.chats.AddAlertFingerprint(&msg, fingerprint)
+	assert.Nil(t, err)
+
+	found, err := bot.chats.GetFingerprintMessage(&chat, fingerprint)
+	assert.Nil(t, err)
+	assert.Equal(t, msg.ID, found.ID)
+	assert.Equal(t, chat.ID, found.Chat.ID)
+
+	err = bot.chats.RemoveAlertMessage(&msg)
+	assert.Nil(t, err)
+	_, err = bot.chats.GetAlertMessage(&msg)
+	assert.NotNil(t, err)
+	_, err = bot.chats.GetFingerprintMessage(&chat, fingerprint)
+	assert.NotNil(t, err)
+}
+
+func TestRoutes(t *testing.T) {
+	first := telebot.Chat{ID: 4243}
+	second := telebot.Chat{ID: -4244}
+
+	assert.Nil(t, bot.chats.AddRouteChat("team-db", &first))
+	assert.Nil(t, bot.chats.AddRouteChat("team-db", &second))
+	assert.Nil(t, bot.chats.AddRouteChat("team-db", &first))
+
+	route, err := bot.chats.GetRoute("team-db")
+	assert.Nil(t, err)
+	assert.Equal(t, []int64{first.ID, second.ID}, route.ChatIDs)
+
+	chats := routeChats(route, []ChatInfo{{Chat: &first}, {Chat: &telebot.Chat{ID: 1}}})
+	assert.Equal(t, 1, len(chats))
+	assert.Equal(t, first.ID, chats[0].Chat.ID)
+
+	assert.Nil(t, bot.chats.RemoveRouteChat("team-db", &first))
+	assert.NotNil(t, bot.chats.RemoveRouteChat("team-db", &first))
+	assert.Nil(t, bot.chats.RemoveRouteChat("team-db", &second))
+
+	route, err = bot.chats.GetRoute("team-db")
+	assert.Nil(t, err)
+	assert.Empty(t, route.ChatIDs)
+}
+
+func TestNamespace(t *testing.T) {
+	kvStore, err := boltdb.New([]string{"/tmp/bot-namespace.db"}, &store.Config{Bucket: "alertmanager"})
+	assert.Nil(t, err)
+	defer os.Remove("/tmp/bot-namespace.db")
+	defer kvStore.Close()
+
+	first, err := NewChatStore(kvStore)
+	assert.Nil(t, err)
+	second, err := NewChatStore(kvStore, WithNamespace("bots/payments"))
+	assert.Nil(t, err)
+
+	chat := telebot.Chat{ID: 4245}
+	assert.Nil(t, second.AddChat(&chat))
+
+	chats, err := first.List()
+	assert.Nil(t, err)
+	assert.Empty(t, chats)
+
+	chats, err = second.List()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(chats))
+	assert.Equal(t, chat.ID, chats[0].Chat.ID)
+}
+
+func TestMuteExpiry(t *testing.T) {
+	chat := telebot.Chat{ID: 4246}
+	assert.Nil(t, bot.chats.AddChat(&chat))
+
+	staging, err := ParseMatchers(`environment="staging"`)
+	assert.Nil(t, err)
+	production, err := ParseMatchers(`environment="production"`)
+	assert.Nil(t, err)
+
+	now := time.Now()
+	assert.Nil(t, bot.chats.Mute(&chat, staging, now.Add(2*time.Hour)))
+	assert.Nil(t, bot.chats.Mute(&chat, production, time.Time{}))
+
+	lapsed, err := bot.chats.ExpireMutes(now.Add(time.Hour))
+	assert.Nil(t, err)
+	assert.Empty(t, lapsed)
+
+	lapsed, err = bot.chats.ExpireMutes(now.Add(3 * time.Hour))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(lapsed))
+	assert.Equal(t, chat.ID, lapsed[0].Chat.ID)
+	assert.Equal(t, 1, len(lapsed[0].Mutes))
+	assert.Equal(t, staging.String(), lapsed[0].Mutes[0].Matchers.String())
+
+	chatInfo, err := bot.chats.GetChatInfo(&chat)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(chatInfo.Mutes))
+	assert.Equal(t, production.String(), chatInfo.Mutes[0].Matchers.String())
+
+	// Muting again without a duration keeps it muted forever
+	assert.Nil(t, bot.chats.Mute(&chat, production, now.Add(time.Hour)))
+	assert.Nil(t, bot.chats.Mute(&chat, production, time.Time{}))
+
+	lapsed, err = bot.chats.ExpireMutes(now.Add(3 * time.Hour))
+	assert.Nil(t, err)
+	assert.Empty(t, lapsed)
+}
+
+func TestHeldAlerts(t *testing.T) {
+	chat := telebot.Chat{ID: 12}
+	other := telebot.Chat{ID: 123}
+
+	assert.Nil(t, bot.chats.HoldAlerts(&chat, template.Data{Alerts: template.Alerts{{Labels: template.KV{"alertname": "First"}}}}))
+	assert.Nil(t, bot.chats.HoldAlerts(&chat, template.Data{Alerts: template.Alerts{{Labels: template.KV{"alertname": "Second"}}}}))
+	assert.Nil(t, bot.chats.HoldAlerts(&other, template.Data{Alerts: template.Alerts{{Labels: template.KV{"alertname": "Other"}}}}))
+
+	held, keys, err := bot.chats.HeldAlerts(&chat)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(held))
+	assert.Equal(t, 2, len(keys))
+	assert.Equal(t, "First", held[0].Alerts[0].Labels["alertname"])
+	assert.Equal(t, "Second", held[1].Alerts[0].Labels["alertname"])
+
+	assert.Nil(t, bot.chats.RemoveHeldAlerts(keys))
+
+	held, _, err = bot.chats.HeldAlerts(&chat)
+	assert.Nil(t, err)
+	assert.Empty(t, held)
+
+	held, keys, err = bot.chats.HeldAlerts(&other)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(held))
+	assert.Nil(t, bot.chats.RemoveHeldAlerts(keys))
+}
+
+func TestUserRoles(t *testing.T) {
+	roles, err := bot.chats.GetUserRoles(42)
+	assert.Nil(t, err)
+	assert.Equal(t, RoleNone, roles.In(-100))
+
+	assert.Nil(t, bot.chats.GrantRole(42, 0, RoleViewer))
+	assert.Nil(t, bot.chats.GrantRole(42, -100, RoleResponder))
+
+	roles, err = bot.chats.GetUserRoles(42)
+	assert.Nil(t, err)
+	assert.Equal(t, RoleResponder, roles.In(-100))
+	assert.Equal(t, RoleViewer, roles.In(-200))
+
+	assert.Equal(t, RoleResponder, bot.userRole(42, -100))
+
+	assert.Nil(t, bot.chats.RevokeRole(42, 0))
+	assert.NotNil(t, bot.chats.RevokeRole(42, 0))
+	assert.Equal(t, RoleNone, bot.userRole(42, -200))
+
+	assert.Nil(t, bot.chats.RevokeRole(42, -100))
+	assert.NotNil(t, bot.chats.RevokeRole(42, -100))
+	assert.Equal(t, RoleNone, bot.userRole(42, -100))
+}
+
+func TestDeliverWebhookInOrder(t *testing.T) {
+	b := &Bot{logger: log.NewNopLogger(), chats: bot.chats, deliveries: newWebhookDeliveries(), redeliver: make(chan struct{}, 1)}
+
+	first := &telebot.Chat{ID: -5001}
+	second := &telebot.Chat{ID: -5002}
+	chats := []ChatInfo{
+		{Chat: first, Mode: modeDigest},
+		{Chat: second, Mode: modeDigest},
+	}
+	w := notify.WebhookMessage{Data: &template.Data{
+		Status: "firing",
+		Alerts: template.Alerts{{Status: "firing", Labels: template.KV{"alertname": "NodeDown"}}},
+	}}
+
+	// An earlier webhook is still sent to the second chat, this one has to wait for it there
+	blocked := map[int64]bool{second.ID: true}
+	var result error
+	b.deliverWebhook("1", w, chats, blocked, func(err error) { result = err })
+	assert.Equal(t, errDeliveryDeferred, result)
+	assert.True(t, b.deliveries.isDelivered("1", first.ID))
+	assert.False(t, b.deliveries.isDelivered("1", second.ID))
+	assert.True(t, blocked[first.ID])
+
+	// Once it was, the webhook is only sent to the chat that didn't get it yet
+	b.deliverWebhook("1", w, chats, make(map[int64]bool), func(err error) { result = err })
+	assert.Nil(t, result)
+	assert.True(t, b.deliveries.isDelivered("1", second.ID))
+	assert.Empty(t, b.deliveries.chatsSending("1"))
+
+	for _, chat := range []*telebot.Chat{first, second} {
+		held, keys, err := bot.chats.HeldAlerts(chat)
+		assert.Nil(t, err)
+		assert.Len(t, held, 1)
+		assert.Nil(t, bot.chats.RemoveHeldAlerts(keys))
+	}
+}
+
+func TestRoleScope(t *testing.T) {
+	// An admin of a single chat can't make themselves an admin of all chats
+	assert.Nil(t, bot.chats.GrantRole(43, -100, RoleAdmin))
+	assert.NotNil(t, bot.checkRoleScope(43, -100, 0, RoleAdmin))
+	assert.NotNil(t, bot.checkRoleScope(43, -100, -200, RoleViewer))
+	assert.Nil(t, bot.checkRoleScope(43, -100, -100, RoleResponder))
+
+	grant := &telebot.Message{Text: "/grant 43 admin", Sender: &telebot.User{ID: 43}, Chat: &telebot.Chat{ID: -100}}
+	assert.True(t, bot.commandAllowed(grant))
+	routeAdd := &telebot.Message{Text: "/route_add team", Sender: &telebot.User{ID: 43}, Chat: &telebot.Chat{ID: -100}}
+	assert.False(t, bot.commandAllowed(routeAdd))
+
+	// A responder of the chat can't grant admin in it
+	assert.Nil(t, bot.chats.GrantRole(44, -100, RoleResponder))
+	assert.NotNil(t, bot.checkRoleScope(44, -100, -100, RoleAdmin))
+
+	// Admins of all chats may grant anything anywhere
+	assert.Nil(t, bot.chats.GrantRole(45, 0, RoleAdmin))
+	assert.Nil(t, bot.checkRoleScope(45, -100, 0, RoleAdmin))
+	assert.True(t, bot.commandAllowed(&telebot.Message{Text: "/route_add team", Sender: &telebot.User{ID: 45}, Chat: &telebot.Chat{ID: -100}}))
+}
+
+func TestMigrateChat(t *testing.T) {
+	group := telebot.Chat{ID: -4247, Type: telebot.ChatGroup, Title: "Team"}
+	assert.Nil(t, bot.chats.AddChat(&group))
+	assert.Nil(t, bot.chats.AddRouteChat("team-migrate", &group))
+	assert.Nil(t, bot.chats.GrantRole(43, group.ID, RoleResponder))
+	assert.Nil(t, bot.chats.HoldAlerts(&group, template.Data{Alerts: template.Alerts{{Labels: template.KV{"alertname": "Held"}}}}))
+
+	assert.Nil(t, bot.chats.MigrateChat(group.ID, -1004247))
+
+	_, err := bot.chats.GetChatInfo(&group)
+	assert.NotNil(t, err)
+
+	supergroup := telebot.Chat{ID: -1004247}
+	chatInfo, err := bot.chats.GetChatInfo(&supergroup)
+	assert.Nil(t, err)
+	assert.Equal(t, supergroup.ID, chatInfo.Chat.ID)
+	assert.Equal(t, telebot.ChatSuperGroup, chatInfo.Chat.Type)
+	assert.Equal(t, "Team", chatInfo.Chat.Title)
+
+	route, err := bot.chats.GetRoute("team-migrate")
+	assert.Nil(t, err)
+	assert.Equal(t, []int64{supergroup.ID}, route.ChatIDs)
+
+	roles, err := bot.chats.GetUserRoles(43)
+	assert.Nil(t, err)
+	assert.Equal(t, RoleResponder, roles.In(supergroup.ID))
+	assert.Equal(t, RoleNone, roles.In(group.ID))
+
+	held, keys, err := bot.chats.HeldAlerts(&supergroup)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(held))
+	assert.Nil(t, bot.chats.RemoveHeldAlerts(keys))
+
+	// Migrating again does nothing
+	assert.Nil(t, bot.chats.MigrateChat(group.ID, supergroup.ID))
+
+	assert.Nil(t, bot.chats.RevokeRole(43, supergroup.ID))
+	assert.Nil(t, bot.chats.RemoveRouteChat("team-migrate", &supergroup))
+	assert.Nil(t, bot.chats.RemoveChat(&supergroup))
+}
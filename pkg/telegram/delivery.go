@@ -0,0 +1,145 @@
+package telegram
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// webhookRedeliveryInterval is how often webhooks that failed to be delivered are retried
+const webhookRedeliveryInterval = time.Minute
+
+// errDeliveryDeferred is reported for webhooks that weren't sent to some chats yet,
+// because an earlier webhook is still being sent to them.
+var errDeliveryDeferred = errors.New("delivery deferred until earlier webhooks were sent")
+
+// webhookDeliveries tracks the webhooks being delivered and the chats that already received them.
+// Chats that received a webhook are skipped when it is delivered again after a failure.
+type webhookDeliveries struct {
+	mu        sync.Mutex
+	inFlight  map[string]bool
+	delivered map[string]map[int64]bool
+	// sendingTo are the chats the webhooks in flight are still being sent to
+	sendingTo map[string]map[int64]bool
+}
+
+func newWebhookDeliveries() *webhookDeliveries {
+	return &webhookDeliveries{
+		inFlight:  make(map[string]bool),
+		delivered: make(map[string]map[int64]bool),
+		sendingTo: make(map[string]map[int64]bool),
+	}
+}
+
+// start marks the webhook as being delivered, it returns false if it already is
+func (d *webhookDeliveries) start(id string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.inFlight[id] {
+		return false
+	}
+	d.inFlight[id] = true
+	return true
+}
+
+// isDelivered returns whether the chat already received the webhook
+func (d *webhookDeliveries) isDelivered(id string, chatID int64) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.delivered[id][chatID]
+}
+
+func (d *webhookDeliveries) markDelivered(id string, chatID int64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.delivered[id] == nil {
+		d.delivered[id] = make(map[int64]bool)
+	}
+	d.delivered[id][chatID] = true
+}
+
+// sending marks the webhook as being sent to the chat
+func (d *webhookDeliveries) sending(id string, chatID int64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.sendingTo[id] == nil {
+		d.sendingTo[id] = make(map[int64]bool)
+	}
+	d.sendingTo[id][chatID] = true
+}
+
+// sent marks the webhook's messages to the chat as sent or given up on
+func (d *webhookDeliveries) sent(id string, chatID int64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	delete(d.sendingTo[id], chatID)
+}
+
+// chatsSending returns the chats the webhook is still being sent to
+func (d *webhookDeliveries) chatsSending(id string) []int64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	chats := make([]int64, 0, len(d.sendingTo[id]))
+	for chatID := range d.sendingTo[id] {
+		chats = append(chats, chatID)
+	}
+	return chats
+}
+
+// finish marks the webhook as no longer being delivered.
+// Once acknowledged its chats are forgotten, otherwise they're kept for the next attempt.
+func (d *webhookDeliveries) finish(id string, acked bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	delete(d.inFlight, id)
+	delete(d.sendingTo, id)
+	if acked {
+		delete(d.delivered, id)
+	}
+}
+
+// countdown calls done once all of its n parts finished, with the first error reported
+type countdown struct {
+	mu   sync.Mutex
+	n    int
+	err  error
+	done func(error)
+}
+
+func newCountdown(n int, done func(error)) *countdown {
+	c := &countdown{n: n, done: done}
+	if n == 0 {
+		done(nil)
+	}
+	return c
+}
+
+func (c *countdown) finish(err error) {
+	c.mu.Lock()
+	if c.err == nil {
+		c.err = err
+	}
+	c.n--
+	n, err := c.n, c.err
+	c.mu.Unlock()
+
+	if n == 0 {
+		c.done(err)
+	}
+}
+
+// retryable returns whether a failed send is worth delivering again later
+func retryable(err error) bool {
+	if err == errQueueFull || err == errQueueStopped || err == errDeliveryDeferred || transient(err) {
+		return true
+	}
+	_, ok := retryAfter(err)
+	return ok
+}
@@ -0,0 +1,55 @@
+package telegram
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWebhookDeliveries(t *testing.T) {
+	d := newWebhookDeliveries()
+
+	assert.True(t, d.start("1"))
+	assert.False(t, d.start("1"))
+
+	d.markDelivered("1", 42)
+	assert.True(t, d.isDelivered("1", 42))
+	assert.False(t, d.isDelivered("1", 43))
+
+	d.finish("1", false)
+	assert.True(t, d.start("1"))
+	assert.True(t, d.isDelivered("1", 42))
+
+	d.finish("1", true)
+	assert.False(t, d.isDelivered("1", 42))
+}
+
+func TestCountdown(t *testing.T) {
+	var calls int
+	var result error
+	done := func(err error) {
+		calls++
+		result = err
+	}
+
+	newCountdown(0, done)
+	assert.Equal(t, 1, calls)
+	assert.Nil(t, result)
+
+	first := errors.New("first")
+	c := newCountdown(3, done)
+	c.finish(nil)
+	c.finish(first)
+	assert.Equal(t, 1, calls)
+	c.finish(errors.New("second"))
+	assert.Equal(t, 2, calls)
+	assert.Equal(t, first, result)
+}
+
+func TestRetryable(t *testing.T) {
+	assert.True(t, retryable(errQueueFull))
+	assert.True(t, retryable(errors.New("telegram: Too Many Requests: retry after 5 (429)")))
+	assert.True(t, retryable(errors.New("telegram unknown: Bad Gateway (502)")))
+	assert.False(t, retryable(errors.New("telegram: Forbidden: bot was blocked by the user (403)")))
+}
@@ -0,0 +1,283 @@
+package telegram
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/alertmanager/template"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+const (
+	// modeDigest chats get a summary of their alerts every DigestInterval instead of every alert right away
+	modeDigest = "digest"
+	// modeRealtime chats get every alert right away, it's stored as an empty mode
+	modeRealtime = "realtime"
+
+	digestTemplate = "telegram.digest"
+	// minDigestInterval keeps chats from asking for digests more often than they're checked
+	minDigestInterval = time.Minute
+)
+
+// DigestData is the data the telegram.digest template is executed with
+type DigestData struct {
+	Since time.Time
+	Until time.Time
+	// Counts of the alerts by alertname and severity, most frequent first
+	Counts []DigestCount
+	// Firing are the alerts that started firing since the last digest
+	Firing template.Alerts
+	// Resolved are the alerts that resolved since the last digest
+	Resolved    template.Alerts
+	ExternalURL string
+}
+
+// DigestCount is the number of alerts with the same alertname and severity
+type DigestCount struct {
+	Alertname string
+	Severity  string
+	Count     int
+}
+
+func newDigestData(data template.Data, since, until time.Time) DigestData {
+	digest := DigestData{Since: since, Until: until, ExternalURL: data.ExternalURL}
+
+	counts := make(map[DigestCount]int)
+	for _, alert := range data.Alerts {
+		counts[DigestCount{Alertname: alert.Labels["alertname"], Severity: alert.Labels["severity"]}]++
+
+		if alert.Status == "resolved" {
+			digest.Resolved = append(digest.Resolved, alert)
+		} else if !alert.StartsAt.Before(since) {
+			digest.Firing = append(digest.Firing, alert)
+		}
+	}
+
+	for c, n := range counts {
+		c.Count = n
+		digest.Counts = append(digest.Counts, c)
+	}
+	sort.Slice(digest.Counts, func(i, j int) bool {
+		if digest.Counts[i].Count != digest.Counts[j].Count {
+			return digest.Counts[i].Count > digest.Counts[j].Count
+		}
+		if digest.Counts[i].Alertname != digest.Counts[j].Alertname {
+			return digest.Counts[i].Alertname < digest.Counts[j].Alertname
+		}
+		return digest.Counts[i].Severity < digest.Counts[j].Severity
+	})
+
+	return digest
+}
+
+// parseMode parses "realtime" or "digest <interval>" into the mode and its interval
+func parseMode(text string) (string, time.Duration, error) {
+	fields := strings.Fields(text)
+	if len(fields) == 0 {
+		return "", 0, fmt.Errorf("expected %s or %s <interval>", modeRealtime, modeDigest)
+	}
+
+	switch fields[0] {
+	case modeRealtime:
+		if len(fields) > 1 {
+			return "", 0, fmt.Errorf("%s doesn't take an interval", modeRealtime)
+		}
+		return "", 0, nil
+	case modeDigest:
+		if len(fields) != 2 {
+			return "", 0, fmt.Errorf("expected %s <interval>, e.g. %s 30m", modeDigest, modeDigest)
+		}
+		interval, err := time.ParseDuration(fields[1])
+		if err != nil {
+			return "", 0, err
+		}
+		if interval < minDigestInterval {
+			return "", 0, fmt.Errorf("the interval has to be at least %s", minDigestInterval)
+		}
+		return modeDigest, interval, nil
+	default:
+		return "", 0, fmt.Errorf("unknown mode %s, expected %s or %s", fields[0], modeRealtime, modeDigest)
+	}
+}
+
+// heldDeliveryID identifies sending the chat's held alerts, so they're never sent twice at once
+func heldDeliveryID(chatID int64) string {
+	return fmt.Sprintf("held/%d", chatID)
+}
+
+// sendDigests sends a summary of the collected alerts to every digest chat whose interval passed
+func (b *Bot) sendDigests() {
+	chatInfos, err := b.chats.List()
+	if err != nil {
+		level.Warn(b.logger).Log("msg", "failed to list chats to send digests", "err", err)
+		return
+	}
+
+	now := time.Now()
+	for _, chatInfo := range chatInfos {
+		chatInfo := chatInfo
+		if chatInfo.Mode != modeDigest || now.Sub(chatInfo.LastDigest) < chatInfo.DigestInterval {
+			continue
+		}
+
+		id := heldDeliveryID(chatInfo.Chat.ID)
+		if !b.deliveries.start(id) {
+			continue
+		}
+
+		sent := func(keys []string, until time.Time) func(error) {
+			return func(err error) {
+				// the alerts are kept for the next attempt unless the digest was sent completely,
+				// failing for good drops them as every attempt would fail the same way
+				if err != nil && retryable(err) {
+					b.deliveries.finish(id, false)
+					return
+				}
+				b.digests.remove(chatInfo.Chat.ID)
+				if err != nil {
+					b.dropHeldAlerts(chatInfo.Chat, err)
+				}
+				if err := b.chats.RemoveHeldAlerts(keys); err != nil {
+					level.Warn(b.logger).Log("msg", "failed to remove alerts sent with digest", "err", err)
+				}
+				if err := b.chats.SetLastDigest(chatInfo.Chat, until); err != nil {
+					level.Warn(b.logger).Log("msg", "failed to save time of last digest", "err", err)
+				}
+				b.deliveries.finish(id, true)
+			}
+		}
+
+		// a digest sent only partly is finished before the alerts held since make it into the next one
+		if digest := b.digests.get(chatInfo.Chat.ID); digest != nil {
+			b.sendDigestParts(chatInfo.Chat, digest, sent(digest.keys, digest.until))
+			continue
+		}
+
+		held, keys, err := b.chats.HeldAlerts(chatInfo.Chat)
+		if err != nil {
+			level.Warn(b.logger).Log("msg", "failed to get alerts for digest", "err", err)
+			b.deliveries.finish(id, false)
+			continue
+		}
+
+		since := chatInfo.LastDigest
+		if since.IsZero() {
+			since = now.Add(-chatInfo.DigestInterval)
+		}
+
+		b.sendDigest(chatInfo, held, keys, since, now, sent(keys, now))
+	}
+}
+
+// sendDigest sends the summary of the held alerts stored with the keys to the chat, nothing if there are none.
+// Templates not defining telegram.digest send the alerts as one batch with the chat's template instead.
+func (b *Bot) sendDigest(chatInfo ChatInfo, held []template.Data, keys []string, since, until time.Time, done func(error)) {
+	if len(held) == 0 {
+		done(nil)
+		return
+	}
+
+	merged := mergeHeldAlerts(held)
+	if !b.templateDefined(digestTemplate) {
+		b.sendAlerts(chatInfo.Chat, merged, chatInfo.Template, false, done)
+		return
+	}
+	text, err := executeNamed(b.currentTemplates(), digestTemplate, newDigestData(merged, since, until))
+	if err != nil {
+		level.Warn(b.logger).Log("msg", "failed to template digest", "err", err)
+		done(err)
+		return
+	}
+
+	parts := splitMessage(strings.TrimSpace(text), maxMessageLength)
+	digest := &digestParts{parts: parts, sent: make([]bool, len(parts)), keys: keys, until: until}
+	b.digests.set(chatInfo.Chat.ID, digest)
+	b.sendDigestParts(chatInfo.Chat, digest, done)
+}
+
+// sendDigestParts queues the parts of the digest not sent yet
+func (b *Bot) sendDigestParts(chat *telebot.Chat, digest *digestParts, done func(error)) {
+	unsent := digest.unsent()
+	sent := newCountdown(len(unsent), done)
+	for _, i := range unsent {
+		i := i
+		send := func() (*telebot.Message, error) {
+			return b.sendMessage(chat, digest.parts[i], &telebot.SendOptions{ParseMode: telebot.ModeHTML})
+		}
+		b.queue.Enqueue(chat.ID, send, func(msg *telebot.Message, err error) {
+			defer sent.finish(err)
+			if err != nil {
+				level.Warn(b.logger).Log("msg", "failed to send digest", "err", err)
+				b.handleSendError(chat, err)
+				return
+			}
+			digest.markSent(i)
+			if err := b.chats.AddMessage(msg); err != nil {
+				level.Warn(b.logger).Log("msg", "failed to save digest message to store", "err", err)
+			}
+		})
+	}
+}
+
+// digestParts is a rendered digest and which of its parts were sent already
+type digestParts struct {
+	mu    sync.Mutex
+	parts []string
+	sent  []bool
+
+	// keys of the held alerts the digest shows and until when it shows them
+	keys  []string
+	until time.Time
+}
+
+// unsent returns the indexes of the parts not sent yet
+func (d *digestParts) unsent() []int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var unsent []int
+	for i, sent := range d.sent {
+		if !sent {
+			unsent = append(unsent, i)
+		}
+	}
+	return unsent
+}
+
+func (d *digestParts) markSent(i int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.sent[i] = true
+}
+
+// unsentDigests are the digests of the chats that weren't sent completely yet
+type unsentDigests struct {
+	mu      sync.Mutex
+	digests map[int64]*digestParts
+}
+
+func newUnsentDigests() *unsentDigests {
+	return &unsentDigests{digests: make(map[int64]*digestParts)}
+}
+
+func (u *unsentDigests) get(chatID int64) *digestParts {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.digests[chatID]
+}
+
+func (u *unsentDigests) set(chatID int64, d *digestParts) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.digests[chatID] = d
+}
+
+func (u *unsentDigests) remove(chatID int64) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	delete(u.digests, chatID)
+}
@@ -0,0 +1,74 @@
+package telegram
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/alertmanager/template"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseMode(t *testing.T) {
+	mode, interval, err := parseMode("digest 30m")
+	assert.Nil(t, err)
+	assert.Equal(t, modeDigest, mode)
+	assert.Equal(t, 30*time.Minute, interval)
+
+	mode, interval, err = parseMode("realtime")
+	assert.Nil(t, err)
+	assert.Equal(t, "", mode)
+	assert.Equal(t, time.Duration(0), interval)
+
+	for _, text := range []string{"", "digest", "digest soon", "digest 10s", "realtime 5m", "weekly"} {
+		_, _, err := parseMode(text)
+		assert.NotNil(t, err, text)
+	}
+}
+
+func TestDigest(t *testing.T) {
+	template.DefaultFuncs["since"] = func(t time.Time) string { return "1m" }
+	template.DefaultFuncs["duration"] = func(start time.Time, end time.Time) string { return "30m" }
+
+	until := time.Now()
+	since := until.Add(-30 * time.Minute)
+
+	data := template.Data{Alerts: template.Alerts{
+		{Status: "firing", Labels: template.KV{"alertname": "HighLoad", "severity": "warning", "instance": "a"}, StartsAt: since.Add(time.Minute)},
+		{Status: "firing", Labels: template.KV{"alertname": "HighLoad", "severity": "warning", "instance": "b"}, StartsAt: since.Add(-time.Hour)},
+		{Status: "resolved", Labels: template.KV{"alertname": "DiskFull"}, StartsAt: since.Add(-time.Hour)},
+	}}
+
+	digest := newDigestData(data, since, until)
+	assert.Equal(t, []DigestCount{
+		{Alertname: "HighLoad", Severity: "warning", Count: 2},
+		{Alertname: "DiskFull", Severity: "", Count: 1},
+	}, digest.Counts)
+	assert.Equal(t, 1, len(digest.Firing))
+	assert.Equal(t, "a", digest.Firing[0].Labels["instance"])
+	assert.Equal(t, 1, len(digest.Resolved))
+
+	b := &Bot{templatePaths: []string{"../../default.tmpl"}}
+	assert.Nil(t, b.ReloadTemplates())
+
+	out, err := executeNamed(b.currentTemplates(), digestTemplate, digest)
+	assert.Nil(t, err)
+	assert.Contains(t, out, "Digest of the last 30m")
+	assert.Contains(t, out, "2× <b>HighLoad</b> [warning]")
+	assert.Contains(t, out, "Resolved")
+}
+
+func TestUnsentDigests(t *testing.T) {
+	digests := newUnsentDigests()
+	assert.Nil(t, digests.get(1))
+
+	digest := &digestParts{parts: []string{"a", "b", "c"}, sent: make([]bool, 3), keys: []string{"key"}}
+	digests.set(1, digest)
+	assert.Equal(t, []int{0, 1, 2}, digest.unsent())
+
+	digest.markSent(0)
+	digest.markSent(2)
+	assert.Equal(t, []int{1}, digests.get(1).unsent())
+
+	digests.remove(1)
+	assert.Nil(t, digests.get(1))
+}
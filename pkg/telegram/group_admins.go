@@ -0,0 +1,104 @@
+package telegram
+
+import (
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+// groupAdminsTTL is how long the administrators of a group are kept before asking Telegram again
+const groupAdminsTTL = time.Minute
+
+// groupAdminCommands are the commands the admins of a group may run in their own group,
+// if the bot lets group admins manage their groups.
+var groupAdminCommands = map[string]bool{
+	commandHelp:          true,
+	commandStart:         true,
+	commandStop:          true,
+	commandSubscribe:     true,
+	commandUnsubscribe:   true,
+	commandSubscriptions: true,
+	commandMute:          true,
+	commandMuteDel:       true,
+	commandMutes:         true,
+}
+
+// groupAdminAllowed returns whether the message's sender may run its command as an admin of the group it was sent in
+func (b *Bot) groupAdminAllowed(message *telebot.Message) bool {
+	b.mu.RLock()
+	enabled := b.groupAdmins
+	b.mu.RUnlock()
+
+	if !enabled || !groupAdminCommands[commandName(message.Text)] {
+		return false
+	}
+	return b.isGroupAdmin(message.Sender.ID, message.Chat)
+}
+
+// isGroupAdmin returns whether the user is an administrator of the group chat according to Telegram
+func (b *Bot) isGroupAdmin(userID int, chat *telebot.Chat) bool {
+	if chat.Type != telebot.ChatGroup && chat.Type != telebot.ChatSuperGroup {
+		return false
+	}
+
+	now := time.Now()
+	admins, ok := b.adminsOf.get(chat.ID, now)
+	if !ok {
+		members, err := b.telegram.AdminsOf(chat)
+		if err != nil {
+			level.Warn(b.logger).Log("msg", "failed to get the administrators of the group", "err", err, "chat_id", chat.ID)
+			return false
+		}
+		admins = make(map[int]bool)
+		for _, m := range members {
+			if m.User != nil {
+				admins[m.User.ID] = true
+			}
+		}
+		b.adminsOf.set(chat.ID, admins, now)
+	}
+	return admins[userID]
+}
+
+// groupAdminsCache keeps the IDs of the administrators of groups for a while
+type groupAdminsCache struct {
+	mu     sync.Mutex
+	ttl    time.Duration
+	groups map[int64]cachedGroupAdmins
+}
+
+type cachedGroupAdmins struct {
+	admins  map[int]bool
+	expires time.Time
+}
+
+func newGroupAdminsCache(ttl time.Duration) *groupAdminsCache {
+	return &groupAdminsCache{ttl: ttl, groups: make(map[int64]cachedGroupAdmins)}
+}
+
+// get returns the administrators of the group, if they were fetched less than the TTL ago
+func (c *groupAdminsCache) get(chatID int64, now time.Time) (map[int]bool, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cached, ok := c.groups[chatID]
+	if !ok || !now.Before(cached.expires) {
+		return nil, false
+	}
+	return cached.admins, true
+}
+
+// set keeps the administrators of the group fetched at now and forgets the expired groups
+func (c *groupAdminsCache) set(chatID int64, admins map[int]bool, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for id, cached := range c.groups {
+		if !now.Before(cached.expires) {
+			delete(c.groups, id)
+		}
+	}
+	c.groups[chatID] = cachedGroupAdmins{admins: admins, expires: now.Add(c.ttl)}
+}
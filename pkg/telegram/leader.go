@@ -0,0 +1,99 @@
+package telegram
+
+import (
+	"context"
+	"time"
+
+	"github.com/docker/libkv/store"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// leaderRetryInterval is how long to wait before campaigning again after the store failed
+const leaderRetryInterval = 10 * time.Second
+
+// leaderElection elects one of the replicas sharing a store as the leader, by holding a lock in the store.
+// Only the leader talks to Telegram, the followers take over once it loses the lock.
+type leaderElection struct {
+	logger  log.Logger
+	newLock func(*store.LockOptions) (store.Locker, error)
+	id      string
+	ttl     time.Duration
+	retry   time.Duration
+	leader  prometheus.Gauge
+}
+
+// Run calls lead whenever this replica becomes the leader, with a context that's canceled once it isn't anymore.
+// It returns once ctx is canceled or lead returned an error.
+func (e *leaderElection) Run(ctx context.Context, lead func(context.Context) error) error {
+	stop := make(chan struct{})
+	go func() {
+		<-ctx.Done()
+		close(stop)
+	}()
+
+	for {
+		if err := e.term(ctx, stop, lead); err != nil {
+			return err
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+	}
+}
+
+// term campaigns for the lock and leads as long as it's held
+func (e *leaderElection) term(ctx context.Context, stop chan struct{}, lead func(context.Context) error) error {
+	renew := make(chan struct{})
+	defer close(renew)
+
+	lock, err := e.newLock(&store.LockOptions{Value: []byte(e.id), TTL: e.ttl, RenewLock: renew})
+	if err != nil {
+		level.Warn(e.logger).Log("msg", "failed to create leader lock", "err", err)
+		e.wait(ctx)
+		return nil
+	}
+
+	lost, err := lock.Lock(stop)
+	if err != nil {
+		level.Warn(e.logger).Log("msg", "failed to acquire leader lock", "err", err)
+		e.wait(ctx)
+		return nil
+	}
+	if lost == nil {
+		// stopped before the lock was acquired
+		return nil
+	}
+	defer lock.Unlock()
+
+	level.Info(e.logger).Log("msg", "became leader", "id", e.id)
+	e.leader.Set(1)
+	defer e.leader.Set(0)
+
+	leadCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- lead(leadCtx)
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-lost:
+		level.Warn(e.logger).Log("msg", "lost leader lock, following", "id", e.id)
+	case <-ctx.Done():
+	}
+
+	cancel()
+	return <-done
+}
+
+func (e *leaderElection) wait(ctx context.Context) {
+	select {
+	case <-time.After(e.retry):
+	case <-ctx.Done():
+	}
+}
@@ -0,0 +1,83 @@
+package telegram
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/docker/libkv/store"
+	"github.com/go-kit/kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeLock struct {
+	lost     chan struct{}
+	unlocked chan struct{}
+}
+
+func newFakeLock() *fakeLock {
+	return &fakeLock{lost: make(chan struct{}), unlocked: make(chan struct{})}
+}
+
+func (l *fakeLock) Lock(stop chan struct{}) (<-chan struct{}, error) {
+	return l.lost, nil
+}
+
+func (l *fakeLock) Unlock() error {
+	close(l.unlocked)
+	return nil
+}
+
+func TestLeaderElection(t *testing.T) {
+	first, second := newFakeLock(), newFakeLock()
+	locks := []store.Locker{nil, first, second}
+
+	e := &leaderElection{
+		logger: log.NewNopLogger(),
+		newLock: func(options *store.LockOptions) (store.Locker, error) {
+			assert.Equal(t, "replica-1", string(options.Value))
+			lock := locks[0]
+			locks = locks[1:]
+			if lock == nil {
+				return nil, errors.New("connection refused")
+			}
+			return lock, nil
+		},
+		id:     "replica-1",
+		ttl:    15 * time.Second,
+		retry:  time.Millisecond,
+		leader: prometheus.NewGauge(prometheus.GaugeOpts{Name: "leader"}),
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	terms := make(chan context.Context)
+	errc := make(chan error)
+	go func() {
+		errc <- e.Run(ctx, func(ctx context.Context) error {
+			terms <- ctx
+			<-ctx.Done()
+			return nil
+		})
+	}()
+
+	// The store failing first is retried
+	term := <-terms
+	assert.Equal(t, float64(1), testutil.ToFloat64(e.leader))
+
+	// Losing the lock ends the term and the replica campaigns again
+	close(first.lost)
+	<-term.Done()
+	<-first.unlocked
+
+	term = <-terms
+	assert.Equal(t, float64(1), testutil.ToFloat64(e.leader))
+
+	cancel()
+	assert.Nil(t, <-errc)
+	<-term.Done()
+	<-second.unlocked
+	assert.Equal(t, float64(0), testutil.ToFloat64(e.leader))
+}
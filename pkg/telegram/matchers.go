@@ -0,0 +1,163 @@
+package telegram
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/prometheus/alertmanager/template"
+)
+
+// MatchType is the operator used to compare a label's value
+type MatchType string
+
+// Prometheus-style match types
+const (
+	MatchEqual     MatchType = "="
+	MatchNotEqual  MatchType = "!="
+	MatchRegexp    MatchType = "=~"
+	MatchNotRegexp MatchType = "!~"
+)
+
+// LabelMatcherRegexp matches a single matcher like severity=~"critical|page" or severity=critical
+const LabelMatcherRegexp = `(\w+)\s*(=~|!~|!=|=)\s*(?:"([^"]*)"|([^\s"]+))`
+
+// Matcher matches an alert's label against a value
+type Matcher struct {
+	Name  string
+	Type  MatchType
+	Value string
+
+	// re is the compiled Value of regexp matchers
+	re *regexp.Regexp
+}
+
+// NewMatcher returns a matcher, compiling the value of regexp matchers once
+func NewMatcher(name string, t MatchType, value string) (Matcher, error) {
+	m := Matcher{Name: name, Type: t, Value: value}
+	if t == MatchRegexp || t == MatchNotRegexp {
+		re, err := regexp.Compile("^(?:" + value + ")$")
+		if err != nil {
+			return Matcher{}, err
+		}
+		m.re = re
+	}
+	return m, nil
+}
+
+// UnmarshalJSON compiles the value of regexp matchers read from the store
+func (m *Matcher) UnmarshalJSON(data []byte) error {
+	type matcher Matcher
+	var raw matcher
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	parsed, err := NewMatcher(raw.Name, raw.Type, raw.Value)
+	if err != nil {
+		return err
+	}
+	*m = parsed
+	return nil
+}
+
+// Matches returns whether the labels satisfy the matcher.
+// A missing label is treated as an empty value, just like Prometheus does.
+func (m Matcher) Matches(labels template.KV) bool {
+	value := labels[m.Name]
+	switch m.Type {
+	case MatchEqual:
+		return value == m.Value
+	case MatchNotEqual:
+		return value != m.Value
+	case MatchRegexp, MatchNotRegexp:
+		re := m.re
+		if re == nil {
+			// matchers not created with NewMatcher
+			compiled, err := NewMatcher(m.Name, m.Type, m.Value)
+			if err != nil {
+				return false
+			}
+			re = compiled.re
+		}
+		return re.MatchString(value) == (m.Type == MatchRegexp)
+	}
+	return false
+}
+
+func (m Matcher) String() string {
+	return fmt.Sprintf(`%s%s"%s"`, m.Name, m.Type, m.Value)
+}
+
+// Matchers only match if all of them match
+type Matchers []Matcher
+
+// Matches returns whether the labels satisfy all matchers.
+func (ms Matchers) Matches(labels template.KV) bool {
+	for _, m := range ms {
+		if !m.Matches(labels) {
+			return false
+		}
+	}
+	return true
+}
+
+func (ms Matchers) String() string {
+	s := make([]string, 0, len(ms))
+	for _, m := range ms {
+		s = append(s, m.String())
+	}
+	return strings.Join(s, " ")
+}
+
+// ParseMatchers parses all matchers like team="payments" severity=~"critical|page" in text.
+// Values without whitespace may be given without quotes, e.g. severity=critical.
+// Anything but whitespace between the matchers is an error, so typos aren't silently ignored.
+func ParseMatchers(text string) (Matchers, error) {
+	matchers, rest, err := parseLeadingMatchers(text)
+	if err != nil {
+		return nil, err
+	}
+	if err := unparsed(rest); err != nil {
+		return nil, err
+	}
+	if len(matchers) == 0 {
+		return nil, errors.New("no matchers were found")
+	}
+	return matchers, nil
+}
+
+// parseLeadingMatchers parses the matchers at the beginning of text,
+// up to the first text that isn't a matcher, which is returned trimmed as the rest.
+func parseLeadingMatchers(text string) (Matchers, string, error) {
+	var matchers Matchers
+	end := 0
+	for _, loc := range regexp.MustCompile(LabelMatcherRegexp).FindAllStringSubmatchIndex(text, -1) {
+		if strings.TrimSpace(text[end:loc[0]]) != "" {
+			break
+		}
+		end = loc[1]
+
+		m := make([]string, len(loc)/2)
+		for i := range m {
+			if loc[2*i] >= 0 {
+				m[i] = text[loc[2*i]:loc[2*i+1]]
+			}
+		}
+		matcher, err := NewMatcher(m[1], MatchType(m[2]), m[3]+m[4])
+		if err != nil {
+			return nil, "", err
+		}
+		matchers = append(matchers, matcher)
+	}
+	return matchers, strings.TrimSpace(text[end:]), nil
+}
+
+// unparsed returns an error if the text between matchers isn't only whitespace
+func unparsed(text string) error {
+	if text = strings.TrimSpace(text); text != "" {
+		return fmt.Errorf("unexpected %q, expected matchers like team=\"payments\"", text)
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package telegram
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/prometheus/alertmanager/template"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseMatchers(t *testing.T) {
+	matchers, err := ParseMatchers(`severity=~"critical|page" team="payments" env!="dev" cluster!~"test-.*"`)
+	assert.Nil(t, err)
+	assert.Equal(t, 4, len(matchers))
+	assert.Equal(t, MatchRegexp, matchers[0].Type)
+	assert.Equal(t, "critical|page", matchers[0].Value)
+	assert.NotNil(t, matchers[0].re)
+	assert.Equal(t, MatchNotEqual, matchers[2].Type)
+	assert.Nil(t, matchers[2].re)
+	assert.Equal(t, `severity=~"critical|page" team="payments" env!="dev" cluster!~"test-.*"`, matchers.String())
+
+	matchers, err = ParseMatchers(`severity=critical env=prod`)
+	assert.Nil(t, err)
+	assert.Equal(t, `severity="critical" env="prod"`, matchers.String())
+
+	_, err = ParseMatchers(`severity critical`)
+	assert.NotNil(t, err)
+
+	_, err = ParseMatchers(`severity=critical team: payments`)
+	assert.EqualError(t, err, `unexpected "team: payments", expected matchers like team="payments"`)
+
+	_, err = ParseMatchers(`severity="critical`)
+	assert.NotNil(t, err)
+
+	_, err = ParseMatchers(`, severity=critical`)
+	assert.NotNil(t, err)
+
+	_, err = ParseMatchers(`severity=~"(critical"`)
+	assert.NotNil(t, err)
+}
+
+func TestMatchersMatches(t *testing.T) {
+	matchers, err := ParseMatchers(`severity=~"critical|page" team="payments" env!="dev"`)
+	assert.Nil(t, err)
+
+	assert.True(t, matchers.Matches(template.KV{"severity": "page", "team": "payments"}))
+	assert.False(t, matchers.Matches(template.KV{"severity": "pager", "team": "payments"}))
+	assert.False(t, matchers.Matches(template.KV{"severity": "critical", "team": "payments", "env": "dev"}))
+	assert.False(t, matchers.Matches(template.KV{"severity": "critical"}))
+}
+
+func TestMatcherJSON(t *testing.T) {
+	matchers, err := ParseMatchers(`severity=~"critical|page" team="payments"`)
+	assert.Nil(t, err)
+
+	data, err := json.Marshal(matchers)
+	assert.Nil(t, err)
+
+	var read Matchers
+	assert.Nil(t, json.Unmarshal(data, &read))
+	assert.Equal(t, matchers, read)
+	assert.True(t, read.Matches(template.KV{"severity": "page", "team": "payments"}))
+
+	assert.NotNil(t, json.Unmarshal([]byte(`[{"Name":"severity","Type":"=~","Value":"(critical"}]`), &read))
+}
+
+func TestChatInfoSubscribed(t *testing.T) {
+	chatInfo := ChatInfo{}
+	assert.True(t, chatInfo.Subscribed(template.KV{"team": "db"}))
+
+	chatInfo.Subscribe(Matchers{{Name: "team", Type: MatchEqual, Value: "payments"}})
+	chatInfo.Subscribe(Matchers{{Name: "severity", Type: MatchEqual, Value: "critical"}})
+	chatInfo.Subscribe(Matchers{{Name: "team", Type: MatchEqual, Value: "payments"}})
+	assert.Equal(t, 2, len(chatInfo.Subscriptions))
+
+	assert.True(t, chatInfo.Subscribed(template.KV{"team": "payments"}))
+	assert.True(t, chatInfo.Subscribed(template.KV{"team": "db", "severity": "critical"}))
+	assert.False(t, chatInfo.Subscribed(template.KV{"team": "db"}))
+
+	assert.Nil(t, chatInfo.Unsubscribe(1))
+	assert.False(t, chatInfo.Subscribed(template.KV{"team": "payments"}))
+	assert.NotNil(t, chatInfo.Unsubscribe(2))
+}
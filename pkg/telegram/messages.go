@@ -0,0 +1,200 @@
+package telegram
+
+import (
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/prometheus/alertmanager/template"
+)
+
+// telegram API can only support 4096 bytes per message
+const maxMessageLength = 4096
+
+var htmlTagRegexp = regexp.MustCompile(`<(/?)([a-zA-Z]+)[^>]*>`)
+
+// renderedMessage is a message ready to be sent together with the alerts it shows
+type renderedMessage struct {
+	Text   string
+	Alerts template.Alerts
+}
+
+// renderMessages renders the alerts with the named template into as few messages of at most limit bytes as possible.
+// Alerts are split across messages on alert boundaries, only an alert too big
+// for a message on its own is split within its text.
+func (b *Bot) renderMessages(data template.Data, limit int, name string) ([]renderedMessage, error) {
+	var messages []renderedMessage
+
+	alerts := data.Alerts
+	for len(alerts) > 0 {
+		text, n, err := b.renderFitting(data, alerts, limit, name)
+		if err != nil {
+			return nil, err
+		}
+
+		parts := splitMessage(text, limit)
+		for i, part := range parts {
+			m := renderedMessage{Text: part}
+			if i == len(parts)-1 {
+				m.Alerts = alerts[:n]
+			}
+			messages = append(messages, m)
+		}
+		alerts = alerts[n:]
+	}
+
+	return messages, nil
+}
+
+// renderFitting renders as many of the alerts as fit into limit bytes and returns the text and how many alerts it shows.
+// The cut is found by binary search, as rendering the alerts one by one is quadratic for big groups.
+// The first alert is always rendered, even if it doesn't fit on its own.
+func (b *Bot) renderFitting(data template.Data, alerts template.Alerts, limit int, name string) (string, int, error) {
+	render := func(n int) (string, error) {
+		data.Alerts = alerts[:n]
+		return b.executeTemplate(name, data)
+	}
+
+	text, err := render(len(alerts))
+	if err != nil || len(text) <= limit || len(alerts) == 1 {
+		return text, len(alerts), err
+	}
+
+	text, err = render(1)
+	if err != nil || len(text) > limit {
+		return text, 1, err
+	}
+
+	// alerts[:lo] fit, alerts[:hi+1] don't
+	lo, hi := 1, len(alerts)-1
+	for lo < hi {
+		mid := (lo + hi + 1) / 2
+		out, err := render(mid)
+		if err != nil {
+			return "", 0, err
+		}
+		if len(out) <= limit {
+			lo, text = mid, out
+		} else {
+			hi = mid - 1
+		}
+	}
+	return text, lo, nil
+}
+
+// splitMessage splits an HTML message into parts of at most limit bytes, e.g. maxMessageLength.
+// Parts are preferably split between alerts and every part's HTML tags are balanced.
+func splitMessage(str string, limit int) []string {
+	if len(str) <= limit {
+		return []string{str}
+	}
+
+	// the tags closed and reopened around the chunks aren't known before chunking,
+	// so chunk again leaving more room whenever they didn't fit
+	reserve := 0
+	for {
+		parts, tags := balanceChunks(chunkMessage(str, limit-reserve))
+		if tags <= reserve || reserve >= limit/2 {
+			return parts
+		}
+		reserve = tags
+	}
+}
+
+// balanceChunks closes the html tags still open at the end of every chunk and reopens them in the next one.
+// It returns the balanced parts and the most bytes added to a chunk.
+func balanceChunks(chunks []string) ([]string, int) {
+	var parts []string
+	var open []string
+	tags := 0
+	for _, chunk := range chunks {
+		part := strings.Join(open, "") + chunk
+		open = openTags(part)
+		for i := len(open) - 1; i >= 0; i-- {
+			part += closingTag(open[i])
+		}
+		if len(part)-len(chunk) > tags {
+			tags = len(part) - len(chunk)
+		}
+		parts = append(parts, part)
+	}
+	return parts, tags
+}
+
+// chunkMessage splits str into chunks of at most limit bytes,
+// splitting between alerts, then lines and only then within a line.
+func chunkMessage(str string, limit int) []string {
+	var chunks []string
+	var current string
+
+	flush := func() {
+		if strings.TrimSpace(current) != "" {
+			chunks = append(chunks, current)
+		}
+		current = ""
+	}
+
+	for _, block := range strings.SplitAfter(str, "\n\n") {
+		if len(current)+len(block) > limit {
+			flush()
+		}
+		for len(block) > limit {
+			i := cutIndex(block, limit)
+			current = block[:i]
+			flush()
+			block = block[i:]
+		}
+		current += block
+	}
+	flush()
+
+	return chunks
+}
+
+// cutIndex returns where to cut str to get at most limit bytes,
+// preferring the end of a line and never cutting a rune, html tag or entity.
+func cutIndex(str string, limit int) int {
+	i := limit
+	if nl := strings.LastIndex(str[:limit], "\n"); nl > 0 {
+		i = nl + 1
+	}
+	for i > 0 && !utf8.RuneStart(str[i]) {
+		i--
+	}
+	if lt := strings.LastIndex(str[:i], "<"); lt > strings.LastIndex(str[:i], ">") && lt > 0 {
+		i = lt
+	}
+	if amp := strings.LastIndex(str[:i], "&"); amp > strings.LastIndex(str[:i], ";") && amp > 0 {
+		i = amp
+	}
+	if i == 0 {
+		return limit
+	}
+	return i
+}
+
+// openTags returns the opening tags of str that are not closed at its end
+func openTags(str string) []string {
+	var open []string
+	for _, m := range htmlTagRegexp.FindAllStringSubmatch(str, -1) {
+		if m[1] == "" {
+			open = append(open, m[0])
+			continue
+		}
+		for i := len(open) - 1; i >= 0; i-- {
+			if tagName(open[i]) == strings.ToLower(m[2]) {
+				open = append(open[:i], open[i+1:]...)
+				break
+			}
+		}
+	}
+	return open
+}
+
+func tagName(tag string) string {
+	return strings.ToLower(htmlTagRegexp.FindStringSubmatch(tag)[2])
+}
+
+func closingTag(tag string) string {
+	return "</" + tagName(tag) + ">"
+}
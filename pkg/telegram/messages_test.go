@@ -0,0 +1,112 @@
+package telegram
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/alertmanager/template"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitMessageShort(t *testing.T) {
+	assert.Equal(t, []string{"<b>FIRING</b>"}, splitMessage("<b>FIRING</b>", maxMessageLength))
+}
+
+func TestSplitMessageOnAlertBoundaries(t *testing.T) {
+	alert := "🔥 <b>FIRING</b> 🔥\n<b>NodeDown</b>\n" + strings.Repeat("x", 100) + "\n\n"
+	str := strings.Repeat(alert, 100)
+
+	parts := splitMessage(str, maxMessageLength)
+	assert.True(t, len(parts) > 1)
+	for _, part := range parts {
+		assert.True(t, len(part) <= maxMessageLength)
+		assert.True(t, strings.HasPrefix(part, "🔥 <b>FIRING</b>"))
+		assert.Empty(t, openTags(part))
+	}
+	assert.Equal(t, str, strings.Join(parts, ""))
+}
+
+func TestSplitMessageBalancesTags(t *testing.T) {
+	str := `<a href="http://example.com"><b>` + strings.Repeat("word ", 2000) + `</b></a>`
+
+	parts := splitMessage(str, maxMessageLength)
+	assert.True(t, len(parts) > 1)
+	for i, part := range parts {
+		assert.True(t, len(part) <= maxMessageLength)
+		assert.Empty(t, openTags(part))
+		if i > 0 {
+			assert.True(t, strings.HasPrefix(part, `<a href="http://example.com"><b>`))
+		}
+	}
+}
+
+func TestSplitMessageLimit(t *testing.T) {
+	str := `<a href="http://example.com"><b>` + strings.Repeat("word ", 2000) + `</b></a>`
+
+	// pages of /alerts leave room for their header
+	limit := maxMessageLength - 300
+	for _, part := range splitMessage(str, limit) {
+		assert.True(t, len(part) <= limit)
+		assert.Empty(t, openTags(part))
+	}
+}
+
+func TestSplitMessageLongTags(t *testing.T) {
+	// reopening the link takes more room than a fixed reserve would leave
+	link := `<a href="http://example.com/` + strings.Repeat("x", 400) + `">`
+	str := "<b><i>" + link + strings.Repeat("word ", 2000) + "</a></i></b>"
+
+	parts := splitMessage(str, maxMessageLength)
+	assert.True(t, len(parts) > 1)
+	for i, part := range parts {
+		assert.True(t, len(part) <= maxMessageLength)
+		assert.Empty(t, openTags(part))
+		if i > 0 {
+			assert.True(t, strings.HasPrefix(part, "<b><i>"+link))
+		}
+	}
+}
+
+func TestRenderMessages(t *testing.T) {
+	dir, err := ioutil.TempDir("", "templates")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "telegram.tmpl")
+	assert.Nil(t, ioutil.WriteFile(path, []byte(`{{ define "telegram.default" }}{{ range .Alerts }}{{ .Labels.alertname }}
+
+{{ end }}{{ end }}`), 0644))
+
+	b := &Bot{templatePaths: []string{path}}
+	assert.Nil(t, b.ReloadTemplates())
+
+	var alerts template.Alerts
+	for i := 0; i < 30; i++ {
+		alerts = append(alerts, template.Alert{Labels: template.KV{"alertname": fmt.Sprintf("alert%02d", i)}})
+	}
+
+	// every alert takes 9 bytes, so 11 of them fit into 100 bytes
+	messages, err := b.renderMessages(template.Data{Alerts: alerts}, 100, "")
+	assert.Nil(t, err)
+	assert.Len(t, messages, 3)
+	assert.Equal(t, alerts[:11], messages[0].Alerts)
+	assert.Equal(t, alerts[11:22], messages[1].Alerts)
+	assert.Equal(t, alerts[22:], messages[2].Alerts)
+	assert.True(t, strings.HasPrefix(messages[1].Text, "alert11\n\n"))
+
+	messages, err = b.renderMessages(template.Data{Alerts: alerts[:1]}, 5, "")
+	assert.Nil(t, err)
+	assert.Len(t, messages, 2)
+	assert.Equal(t, alerts[:1], messages[1].Alerts)
+}
+
+func TestCutIndex(t *testing.T) {
+	assert.Equal(t, 4, cutIndex("abc\ndef", 6))
+	assert.Equal(t, 3, cutIndex("abc<b>def</b>", 5))
+	assert.Equal(t, 3, cutIndex("abc&amp;def", 6))
+	assert.Equal(t, 1, cutIndex("aä", 2))
+}
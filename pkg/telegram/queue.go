@@ -0,0 +1,346 @@
+package telegram
+
+import (
+	"context"
+	"errors"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	pkgerrors "github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+// Telegram's limits, see https://core.telegram.org/bots/faq#my-bot-is-hitting-limits-how-do-i-avoid-this
+const (
+	globalMessagesPerSecond = 30
+	groupMessagesPerMinute  = 20
+	chatMessagesPerSecond   = 1
+
+	maxQueuedMessages  = 10000
+	maxSendAttempts    = 5
+	initialSendBackoff = time.Second
+	idleQueueWait      = time.Minute
+)
+
+var (
+	errQueueFull     = errors.New("send queue is full")
+	errQueueStopped  = errors.New("send queue was stopped")
+	retryAfterRegexp = regexp.MustCompile(`retry after (\d+)`)
+)
+
+// sendFunc does the actual call to Telegram for a queued message
+type sendFunc func() (*telebot.Message, error)
+
+// sentFunc is called with the result once a queued message was sent or given up on
+type sentFunc func(*telebot.Message, error)
+
+type sendJob struct {
+	chatID    int64
+	send      sendFunc
+	sent      sentFunc
+	attempts  int
+	notBefore time.Time
+}
+
+// sendQueue sends messages to Telegram without exceeding its rate limits.
+// Messages to the same chat are sent in order, a rate limited chat doesn't hold up others.
+type sendQueue struct {
+	logger log.Logger
+	// wake is notified whenever a message was enqueued
+	wake   chan struct{}
+	global *windowLimiter
+	chats  map[int64]*chatLimiter
+
+	mu      sync.Mutex
+	pending []*sendJob
+
+	depth   prometheus.Gauge
+	dropped *prometheus.CounterVec
+	retries prometheus.Counter
+}
+
+func newSendQueue(logger log.Logger) *sendQueue {
+	return &sendQueue{
+		logger: logger,
+		wake:   make(chan struct{}, 1),
+		global: &windowLimiter{n: globalMessagesPerSecond, window: time.Second},
+		chats:  make(map[int64]*chatLimiter),
+		depth: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: "alertmanagerbot",
+			Name:      "send_queue_depth",
+			Help:      "Number of messages waiting to be sent to Telegram",
+		}),
+		dropped: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "alertmanagerbot",
+			Name:      "send_dropped_total",
+			Help:      "Number of messages that were never sent to Telegram by reason",
+		}, []string{"reason"}),
+		retries: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "alertmanagerbot",
+			Name:      "send_retries_total",
+			Help:      "Number of retried sends to Telegram",
+		}),
+	}
+}
+
+// Collectors returns the queue's metrics to be registered
+func (q *sendQueue) Collectors() []prometheus.Collector {
+	return []prometheus.Collector{q.depth, q.dropped, q.retries}
+}
+
+// Enqueue queues a message for the chat. sent is called once the message was sent or given up on.
+// Messages are dropped once maxQueuedMessages are waiting to be sent.
+func (q *sendQueue) Enqueue(chatID int64, send sendFunc, sent sentFunc) {
+	q.mu.Lock()
+	if len(q.pending) >= maxQueuedMessages {
+		q.mu.Unlock()
+		q.dropped.WithLabelValues("queue_full").Inc()
+		sent(nil, errQueueFull)
+		return
+	}
+	q.pending = append(q.pending, &sendJob{chatID: chatID, send: send, sent: sent})
+	q.mu.Unlock()
+	q.depth.Inc()
+
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run sends the queued messages until the context is canceled,
+// the messages that weren't sent by then are given up on.
+func (q *sendQueue) Run(ctx context.Context) error {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			q.stop()
+			return nil
+		case <-q.wake:
+		case <-timer.C:
+		}
+
+		wait := q.process()
+
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(wait)
+	}
+}
+
+// stop gives up on all queued messages, so they're delivered again by whoever runs the queue next
+func (q *sendQueue) stop() {
+	q.mu.Lock()
+	pending := q.pending
+	q.pending = nil
+	q.mu.Unlock()
+
+	for _, job := range pending {
+		q.depth.Dec()
+		q.dropped.WithLabelValues("stopped").Inc()
+		job.sent(nil, errQueueStopped)
+	}
+}
+
+// process sends all messages that are allowed to be sent right now
+// and returns how long to wait until the next one might be.
+// The queue isn't locked while sending, so messages can be enqueued in the meantime.
+func (q *sendQueue) process() time.Duration {
+	for {
+		now := time.Now()
+		if wait := q.global.wait(now); wait > 0 {
+			return wait
+		}
+
+		q.mu.Lock()
+		i, wait := q.next(now)
+		if i < 0 {
+			q.evictIdle(now)
+			q.mu.Unlock()
+			return wait
+		}
+		job := q.pending[i]
+		q.pending = append(q.pending[:i], q.pending[i+1:]...)
+		q.mu.Unlock()
+
+		q.global.record(now)
+		q.chat(job.chatID).record(now)
+
+		msg, err := job.send()
+		if err == nil {
+			q.depth.Dec()
+			job.sent(msg, nil)
+			continue
+		}
+
+		job.attempts++
+		retry, ok := retryAfter(err)
+		if ok {
+			if retry <= 0 {
+				retry = initialSendBackoff
+			}
+			q.chat(job.chatID).blockedUntil = time.Now().Add(retry)
+		} else if transient(err) {
+			retry = initialSendBackoff << uint(job.attempts-1)
+		}
+
+		if retry == 0 || job.attempts >= maxSendAttempts {
+			q.depth.Dec()
+			q.dropped.WithLabelValues("failed").Inc()
+			job.sent(nil, err)
+			continue
+		}
+
+		level.Debug(q.logger).Log("msg", "retrying to send message", "chat_id", job.chatID, "retry", retry, "err", err)
+		q.retries.Inc()
+		job.notBefore = time.Now().Add(retry)
+		// messages are only appended in the meantime, the job goes back to where it was
+		q.mu.Lock()
+		q.pending = append(q.pending[:i], append([]*sendJob{job}, q.pending[i:]...)...)
+		q.mu.Unlock()
+	}
+}
+
+// next returns the index of the next message allowed to be sent,
+// otherwise -1 and how long to wait until the next one might be. q.mu must be held.
+func (q *sendQueue) next(now time.Time) (int, time.Duration) {
+	wait := idleQueueWait
+	waiting := make(map[int64]bool)
+
+	for i, job := range q.pending {
+		// keep the order of messages within a chat
+		if waiting[job.chatID] {
+			continue
+		}
+
+		w := q.chat(job.chatID).wait(now)
+		if d := job.notBefore.Sub(now); d > w {
+			w = d
+		}
+		if w <= 0 {
+			return i, 0
+		}
+
+		waiting[job.chatID] = true
+		if w < wait {
+			wait = w
+		}
+	}
+	return -1, wait
+}
+
+// evictIdle forgets the limiters of the chats without queued messages whose window is empty,
+// so chats that got a message once don't stay in memory. q.mu must be held.
+func (q *sendQueue) evictIdle(now time.Time) {
+	queued := make(map[int64]bool, len(q.pending))
+	for _, job := range q.pending {
+		queued[job.chatID] = true
+	}
+	for id, l := range q.chats {
+		if !queued[id] && l.idle(now) {
+			delete(q.chats, id)
+		}
+	}
+}
+
+func (q *sendQueue) chat(id int64) *chatLimiter {
+	l, ok := q.chats[id]
+	if !ok {
+		l = newChatLimiter(id)
+		q.chats[id] = l
+	}
+	return l
+}
+
+// windowLimiter allows at most n events within a sliding window
+type windowLimiter struct {
+	n      int
+	window time.Duration
+	events []time.Time
+}
+
+// wait returns how long to wait until another event is allowed
+func (l *windowLimiter) wait(now time.Time) time.Duration {
+	for len(l.events) > 0 && !l.events[0].Add(l.window).After(now) {
+		l.events = l.events[1:]
+	}
+	if len(l.events) < l.n {
+		return 0
+	}
+	return l.events[0].Add(l.window).Sub(now)
+}
+
+func (l *windowLimiter) record(now time.Time) {
+	l.events = append(l.events, now)
+}
+
+// chatLimiter limits the messages to a single chat
+type chatLimiter struct {
+	windowLimiter
+	blockedUntil time.Time
+}
+
+func newChatLimiter(id int64) *chatLimiter {
+	// group chats have negative IDs
+	if id < 0 {
+		return &chatLimiter{windowLimiter: windowLimiter{n: groupMessagesPerMinute, window: time.Minute}}
+	}
+	return &chatLimiter{windowLimiter: windowLimiter{n: chatMessagesPerSecond, window: time.Second}}
+}
+
+// idle returns whether the chat's limiter doesn't limit it anymore and can be forgotten
+func (l *chatLimiter) idle(now time.Time) bool {
+	return l.windowLimiter.wait(now) == 0 && len(l.events) == 0 && !l.blockedUntil.After(now)
+}
+
+func (l *chatLimiter) wait(now time.Time) time.Duration {
+	wait := l.windowLimiter.wait(now)
+	if d := l.blockedUntil.Sub(now); d > wait {
+		return d
+	}
+	return wait
+}
+
+// retryAfter returns how long Telegram asked to wait before retrying a rate limited request
+func retryAfter(err error) (time.Duration, bool) {
+	if apiErr, ok := err.(*apiError); ok && apiErr.Parameters.RetryAfter > 0 {
+		return time.Duration(apiErr.Parameters.RetryAfter) * time.Second, true
+	}
+	m := retryAfterRegexp.FindStringSubmatch(err.Error())
+	if m == nil {
+		return 0, false
+	}
+	seconds, err := strconv.Atoi(m[1])
+	if err != nil {
+		return 0, false
+	}
+	return time.Duration(seconds) * time.Second, true
+}
+
+// transient returns whether sending might succeed when retried
+func transient(err error) bool {
+	if _, ok := pkgerrors.Cause(err).(net.Error); ok {
+		return true
+	}
+	msg := err.Error()
+	for _, s := range []string{"Internal Server Error", "Bad Gateway", "Service Unavailable", "Gateway Timeout"} {
+		if strings.Contains(msg, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,155 @@
+package telegram
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+func TestWindowLimiter(t *testing.T) {
+	now := time.Now()
+	l := &windowLimiter{n: 2, window: time.Minute}
+
+	assert.Equal(t, time.Duration(0), l.wait(now))
+	l.record(now)
+	assert.Equal(t, time.Duration(0), l.wait(now))
+	l.record(now.Add(10 * time.Second))
+	assert.Equal(t, 30*time.Second, l.wait(now.Add(30*time.Second)))
+	assert.Equal(t, time.Duration(0), l.wait(now.Add(time.Minute)))
+}
+
+func TestRetryAfter(t *testing.T) {
+	retry, ok := retryAfter(errors.New("api error: Too Many Requests: retry after 35"))
+	assert.True(t, ok)
+	assert.Equal(t, 35*time.Second, retry)
+
+	_, ok = retryAfter(errors.New("api error: Bad Request: chat not found"))
+	assert.False(t, ok)
+}
+
+func TestSendQueueProcess(t *testing.T) {
+	q := newSendQueue(log.NewNopLogger())
+
+	var sent []int
+	send := func(id int) sendFunc {
+		return func() (*telebot.Message, error) {
+			return &telebot.Message{ID: id}, nil
+		}
+	}
+	done := func(msg *telebot.Message, err error) {
+		assert.Nil(t, err)
+		sent = append(sent, msg.ID)
+	}
+
+	// A private chat may only get one message per second, other chats aren't held up by it.
+	q.pending = []*sendJob{
+		{chatID: 1, send: send(1), sent: done},
+		{chatID: 1, send: send(2), sent: done},
+		{chatID: 2, send: send(3), sent: done},
+	}
+	wait := q.process()
+	assert.Equal(t, []int{1, 3}, sent)
+	assert.True(t, wait > 0 && wait <= time.Second)
+	assert.Equal(t, 1, len(q.pending))
+}
+
+func TestSendQueueRetryAfter(t *testing.T) {
+	q := newSendQueue(log.NewNopLogger())
+
+	var errs []error
+	q.pending = []*sendJob{{
+		chatID: -1,
+		send: func() (*telebot.Message, error) {
+			return nil, errors.New("api error: Too Many Requests: retry after 10")
+		},
+		sent: func(msg *telebot.Message, err error) {
+			errs = append(errs, err)
+		},
+	}}
+
+	wait := q.process()
+	assert.Empty(t, errs)
+	assert.Equal(t, 1, len(q.pending))
+	assert.Equal(t, 1, q.pending[0].attempts)
+	assert.True(t, wait > 9*time.Second && wait <= 10*time.Second)
+
+	// Errors that aren't worth retrying are given up on right away.
+	q.pending = []*sendJob{{
+		chatID: 2,
+		send: func() (*telebot.Message, error) {
+			return nil, errors.New("api error: Bad Request: chat not found")
+		},
+		sent: func(msg *telebot.Message, err error) {
+			errs = append(errs, err)
+		},
+	}}
+	q.process()
+	assert.Equal(t, 1, len(errs))
+	assert.Empty(t, q.pending)
+}
+
+func TestSendQueueStop(t *testing.T) {
+	q := newSendQueue(log.NewNopLogger())
+
+	var errs []error
+	done := func(msg *telebot.Message, err error) {
+		errs = append(errs, err)
+	}
+	send := func() (*telebot.Message, error) {
+		return &telebot.Message{}, nil
+	}
+
+	q.pending = []*sendJob{{chatID: 1, send: send, sent: done}}
+	q.Enqueue(2, send, done)
+
+	q.stop()
+
+	// Messages that weren't sent are retried once the queue runs again
+	assert.Equal(t, []error{errQueueStopped, errQueueStopped}, errs)
+	assert.True(t, retryable(errQueueStopped))
+	assert.Empty(t, q.pending)
+}
+
+func TestSendQueueFull(t *testing.T) {
+	q := newSendQueue(log.NewNopLogger())
+
+	var dropped int
+	send := func() (*telebot.Message, error) {
+		return &telebot.Message{}, nil
+	}
+	done := func(msg *telebot.Message, err error) {
+		if err == errQueueFull {
+			dropped++
+		}
+	}
+
+	// The queue holds maxQueuedMessages even while nothing is sent
+	for i := 0; i < maxQueuedMessages+1; i++ {
+		q.Enqueue(int64(i), send, done)
+	}
+	assert.Equal(t, maxQueuedMessages, len(q.pending))
+	assert.Equal(t, 1, dropped)
+}
+
+func TestSendQueueEvictIdle(t *testing.T) {
+	q := newSendQueue(log.NewNopLogger())
+
+	now := time.Now()
+	q.chat(1).record(now.Add(-2 * time.Second))
+	q.chat(2).record(now)
+	q.chat(3).blockedUntil = now.Add(time.Minute)
+	q.chat(4).record(now.Add(-2 * time.Second))
+	q.pending = []*sendJob{{chatID: 4, notBefore: now.Add(time.Minute)}}
+
+	q.mu.Lock()
+	q.evictIdle(now)
+	q.mu.Unlock()
+
+	_, ok := q.chats[1]
+	assert.False(t, ok)
+	assert.Equal(t, 3, len(q.chats))
+}
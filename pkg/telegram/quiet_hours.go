@@ -0,0 +1,239 @@
+package telegram
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/prometheus/alertmanager/template"
+)
+
+var weekdayNames = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+// QuietHours is a daily window in the chat's timezone during which non-critical alerts don't notify
+type QuietHours struct {
+	// Start and End are minutes after midnight, a window ending before it starts ends the next day
+	Start int
+	End   int
+	// Weekdays the window starts on, every day if empty
+	Weekdays []time.Weekday
+	// Hold non-critical alerts until the window ends and send them as one batch, instead of silently
+	Hold bool
+}
+
+// Active returns whether t, in the chat's timezone, is within the window
+func (q *QuietHours) Active(t time.Time) bool {
+	minute := t.Hour()*60 + t.Minute()
+
+	if q.Start < q.End {
+		return q.onDay(t.Weekday()) && minute >= q.Start && minute < q.End
+	}
+	if minute >= q.Start {
+		return q.onDay(t.Weekday())
+	}
+	if minute < q.End {
+		return q.onDay(t.AddDate(0, 0, -1).Weekday())
+	}
+	return false
+}
+
+func (q *QuietHours) onDay(day time.Weekday) bool {
+	if len(q.Weekdays) == 0 {
+		return true
+	}
+	for _, d := range q.Weekdays {
+		if d == day {
+			return true
+		}
+	}
+	return false
+}
+
+func (q *QuietHours) String() string {
+	s := fmt.Sprintf("%02d:%02d-%02d:%02d", q.Start/60, q.Start%60, q.End/60, q.End%60)
+	if len(q.Weekdays) > 0 {
+		days := make([]string, 0, len(q.Weekdays))
+		for _, d := range q.Weekdays {
+			days = append(days, weekdayNames[d])
+		}
+		s += " " + strings.Join(days, ",")
+	}
+	if q.Hold {
+		s += " hold"
+	}
+	return s
+}
+
+// parseQuietHours parses "22:00-08:00 [mon-fri] [hold]" into quiet hours
+func parseQuietHours(text string) (*QuietHours, error) {
+	fields := strings.Fields(text)
+	if len(fields) == 0 {
+		return nil, errors.New("expected a window like 22:00-08:00")
+	}
+
+	window := strings.Split(fields[0], "-")
+	if len(window) != 2 {
+		return nil, fmt.Errorf("expected a window like 22:00-08:00, got %s", fields[0])
+	}
+	start, err := parseClock(window[0])
+	if err != nil {
+		return nil, err
+	}
+	end, err := parseClock(window[1])
+	if err != nil {
+		return nil, err
+	}
+	if start == end {
+		return nil, errors.New("the window has to end at another time than it starts")
+	}
+
+	q := &QuietHours{Start: start, End: end}
+	for _, f := range fields[1:] {
+		if f == "hold" {
+			q.Hold = true
+			continue
+		}
+		days, err := parseWeekdays(f)
+		if err != nil {
+			return nil, err
+		}
+		q.Weekdays = append(q.Weekdays, days...)
+	}
+
+	return q, nil
+}
+
+// parseClock parses 22:00 into minutes after midnight
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("expected a time like 22:00, got %s", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// parseWeekdays parses days like mon-fri, sat,sun, weekdays or weekends
+func parseWeekdays(s string) ([]time.Weekday, error) {
+	switch s {
+	case "weekdays":
+		s = "mon-fri"
+	case "weekends":
+		s = "sat,sun"
+	}
+
+	var days []time.Weekday
+	for _, part := range strings.Split(s, ",") {
+		bounds := strings.Split(part, "-")
+		if len(bounds) > 2 {
+			return nil, fmt.Errorf("expected days like mon-fri, got %s", part)
+		}
+		first, err := parseWeekday(bounds[0])
+		if err != nil {
+			return nil, err
+		}
+		last, err := parseWeekday(bounds[len(bounds)-1])
+		if err != nil {
+			return nil, err
+		}
+		for d := first; ; d = (d + 1) % 7 {
+			days = append(days, d)
+			if d == last {
+				break
+			}
+		}
+	}
+	return days, nil
+}
+
+func parseWeekday(s string) (time.Weekday, error) {
+	for i, name := range weekdayNames {
+		if strings.ToLower(s) == name {
+			return time.Weekday(i), nil
+		}
+	}
+	return 0, fmt.Errorf("expected a day like mon, got %s", s)
+}
+
+// holdableAlerts splits the alerts into the ones held during quiet hours and the critical ones sent right away
+func holdableAlerts(alerts template.Alerts) (template.Alerts, template.Alerts) {
+	var held, critical template.Alerts
+	for _, alert := range alerts {
+		if alertSeverity(alert) == severityCritical {
+			critical = append(critical, alert)
+		} else {
+			held = append(held, alert)
+		}
+	}
+	return held, critical
+}
+
+// mergeHeldAlerts merges the data held back, oldest first, into one batch.
+// Only the latest state of every alert is kept, so alerts that resolved meanwhile are sent as resolved.
+// The group and common labels and annotations are the ones shared by all of the merged batches and alerts.
+func mergeHeldAlerts(held []template.Data) template.Data {
+	if len(held) == 0 {
+		return template.Data{}
+	}
+
+	merged := held[len(held)-1]
+	merged.Alerts = nil
+
+	index := make(map[string]int)
+	for _, data := range held {
+		for _, alert := range data.Alerts {
+			fingerprint := alertFingerprint(alert)
+			if i, ok := index[fingerprint]; ok {
+				merged.Alerts[i] = alert
+				continue
+			}
+			index[fingerprint] = len(merged.Alerts)
+			merged.Alerts = append(merged.Alerts, alert)
+		}
+	}
+
+	sort.SliceStable(merged.Alerts, func(i, j int) bool {
+		return merged.Alerts[i].StartsAt.Before(merged.Alerts[j].StartsAt)
+	})
+
+	merged.Status = "resolved"
+	if len(merged.Alerts.Firing()) > 0 {
+		merged.Status = "firing"
+	}
+
+	var groupLabels, labels, annotations []template.KV
+	for _, data := range held {
+		groupLabels = append(groupLabels, data.GroupLabels)
+	}
+	for _, alert := range merged.Alerts {
+		labels = append(labels, alert.Labels)
+		annotations = append(annotations, alert.Annotations)
+	}
+	merged.GroupLabels = commonKV(groupLabels)
+	merged.CommonLabels = commonKV(labels)
+	merged.CommonAnnotations = commonKV(annotations)
+
+	return merged
+}
+
+// commonKV returns the pairs all of the kvs have in common
+func commonKV(kvs []template.KV) template.KV {
+	common := template.KV{}
+	if len(kvs) == 0 {
+		return common
+	}
+	for name, value := range kvs[0] {
+		shared := true
+		for _, kv := range kvs[1:] {
+			if v, ok := kv[name]; !ok || v != value {
+				shared = false
+				break
+			}
+		}
+		if shared {
+			common[name] = value
+		}
+	}
+	return common
+}
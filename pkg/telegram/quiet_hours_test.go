@@ -0,0 +1,93 @@
+package telegram
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/alertmanager/template"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseQuietHours(t *testing.T) {
+	q, err := parseQuietHours("22:00-08:00 mon-fri hold")
+	assert.Nil(t, err)
+	assert.Equal(t, 22*60, q.Start)
+	assert.Equal(t, 8*60, q.End)
+	assert.Equal(t, []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday}, q.Weekdays)
+	assert.True(t, q.Hold)
+	assert.Equal(t, "22:00-08:00 mon,tue,wed,thu,fri hold", q.String())
+
+	q, err = parseQuietHours("12:30-13:30 weekends")
+	assert.Nil(t, err)
+	assert.Equal(t, []time.Weekday{time.Saturday, time.Sunday}, q.Weekdays)
+	assert.False(t, q.Hold)
+
+	for _, text := range []string{"", "22:00", "25:00-08:00", "08:00-08:00", "22:00-08:00 someday"} {
+		_, err := parseQuietHours(text)
+		assert.NotNil(t, err, text)
+	}
+}
+
+func TestQuietHoursActive(t *testing.T) {
+	q, err := parseQuietHours("22:00-08:00 mon-fri")
+	assert.Nil(t, err)
+
+	// 2026-10-16 is a Friday
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2026, 10, day, hour, minute, 0, 0, time.UTC)
+	}
+
+	assert.False(t, q.Active(at(16, 21, 59)))
+	assert.True(t, q.Active(at(16, 22, 0)))
+	assert.True(t, q.Active(at(17, 7, 59)), "Saturday morning after Friday night")
+	assert.False(t, q.Active(at(17, 8, 0)))
+	assert.False(t, q.Active(at(17, 23, 0)), "Saturday night")
+	assert.False(t, q.Active(at(18, 23, 0)), "Sunday night")
+	assert.False(t, q.Active(at(19, 3, 0)), "Monday morning after Sunday night")
+	assert.True(t, q.Active(at(19, 23, 0)), "Monday night")
+
+	q, err = parseQuietHours("12:00-13:00")
+	assert.Nil(t, err)
+	assert.True(t, q.Active(at(18, 12, 30)))
+	assert.False(t, q.Active(at(18, 13, 0)))
+
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	assert.Nil(t, err)
+	chatInfo := ChatInfo{Timezone: "Europe/Berlin", QuietHours: q}
+	assert.Equal(t, berlin.String(), chatInfo.Location().String())
+	assert.True(t, chatInfo.Quiet(at(18, 10, 30)), "12:30 in Berlin")
+	assert.False(t, chatInfo.Quiet(at(18, 12, 30)))
+}
+
+func TestMergeHeldAlerts(t *testing.T) {
+	start := time.Now()
+	firing := template.Alert{Status: "firing", Labels: template.KV{"alertname": "DiskFull"}, StartsAt: start}
+	resolved := firing
+	resolved.Status = "resolved"
+	other := template.Alert{Status: "firing", Labels: template.KV{"alertname": "HighLoad"}, StartsAt: start.Add(time.Minute)}
+
+	merged := mergeHeldAlerts([]template.Data{
+		{Alerts: template.Alerts{firing}, GroupLabels: template.KV{"alertname": "DiskFull"}, CommonLabels: firing.Labels},
+		{Alerts: template.Alerts{other}, GroupLabels: template.KV{"alertname": "HighLoad"}, CommonLabels: other.Labels},
+		{Alerts: template.Alerts{resolved}, GroupLabels: template.KV{"alertname": "DiskFull"}, CommonLabels: resolved.Labels},
+	})
+	assert.Equal(t, 2, len(merged.Alerts))
+	assert.Equal(t, "resolved", merged.Alerts[0].Status)
+	assert.Equal(t, "HighLoad", merged.Alerts[1].Labels["alertname"])
+	assert.Equal(t, "firing", merged.Status)
+	assert.Empty(t, merged.GroupLabels)
+	assert.Empty(t, merged.CommonLabels)
+
+	assert.Equal(t, template.KV{"env": "prod"}, commonKV([]template.KV{
+		{"env": "prod", "alertname": "DiskFull"},
+		{"env": "prod", "alertname": "HighLoad"},
+	}))
+
+	held, critical := holdableAlerts(template.Alerts{
+		{Labels: template.KV{"alertname": "NodeDown", "severity": "critical"}},
+		{Labels: template.KV{"alertname": "HighLoad", "severity": "warning"}},
+	})
+	assert.Equal(t, 1, len(held))
+	assert.Equal(t, 1, len(critical))
+	assert.Equal(t, "NodeDown", critical[0].Labels["alertname"])
+}
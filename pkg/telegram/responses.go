@@ -0,0 +1,117 @@
+package telegram
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hako/durafmt"
+	"github.com/metalmatze/alertmanager-bot/pkg/alertmanager"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+const (
+	silenceTemplate = "telegram.silence"
+	statusTemplate  = "telegram.status"
+)
+
+// SilenceData is the data the telegram.silence template is executed with
+type SilenceData struct {
+	ID        string
+	Alertname string
+	// Matchers are all matchers but the alertname, like severity="critical"
+	Matchers  []string
+	StartsAt  time.Time
+	EndsAt    time.Time
+	CreatedBy string
+	Comment   string
+	Resolved  bool
+	// Now is the time the message is rendered at, to calculate how long the silence lasts
+	Now time.Time
+}
+
+// StatusData is the data the telegram.status template is executed with
+type StatusData struct {
+	Alertmanager alertmanager.StatusResponse
+	Revision     string
+	StartTime    time.Time
+}
+
+func newSilenceData(s alertmanager.Silence) SilenceData {
+	data := SilenceData{
+		ID:        s.ID,
+		StartsAt:  s.StartsAt,
+		EndsAt:    s.EndsAt,
+		CreatedBy: s.CreatedBy,
+		Comment:   s.Comment,
+		Resolved:  alertmanager.Resolved(s),
+		Now:       time.Now(),
+	}
+
+	for _, m := range s.Matchers {
+		if m.Name == "alertname" && m.Operator() == "=" {
+			data.Alertname = m.Value
+			continue
+		}
+		data.Matchers = append(data.Matchers, m.String())
+	}
+
+	return data
+}
+
+// silencesMessage renders the silences with the telegram.silence template.
+// Templates not defining telegram.silence yet fall back to the built-in Markdown message.
+func (b *Bot) silencesMessage(silences ...alertmanager.Silence) (string, telebot.ParseMode, error) {
+	if !b.templateDefined(silenceTemplate) {
+		return markdownSilences(silences), telebot.ModeMarkdown, nil
+	}
+	t := b.currentTemplates()
+
+	var out []string
+	for _, s := range silences {
+		msg, err := executeNamed(t, silenceTemplate, newSilenceData(s))
+		if err != nil {
+			return "", "", err
+		}
+		out = append(out, strings.TrimSpace(msg))
+	}
+
+	return strings.Join(out, "\n\n"), telebot.ModeHTML, nil
+}
+
+func markdownSilences(silences []alertmanager.Silence) string {
+	var out string
+	for _, silence := range silences {
+		out = out + alertmanager.SilenceMessage(silence) + "\n"
+	}
+	return out
+}
+
+// statusMessage renders the status with the telegram.status template.
+// Templates not defining telegram.status yet fall back to the built-in Markdown message.
+func (b *Bot) statusMessage(s alertmanager.StatusResponse) (string, telebot.ParseMode, error) {
+	if !b.templateDefined(statusTemplate) {
+		return markdownStatus(s, b.revision, b.startTime), telebot.ModeMarkdown, nil
+	}
+	msg, err := executeNamed(b.currentTemplates(), statusTemplate, StatusData{
+		Alertmanager: s,
+		Revision:     b.revision,
+		StartTime:    b.startTime,
+	})
+	if err != nil {
+		return "", "", err
+	}
+	return strings.TrimSpace(msg), telebot.ModeHTML, nil
+}
+
+func markdownStatus(s alertmanager.StatusResponse, revision string, startTime time.Time) string {
+	return fmt.Sprintf(
+		"*AlertManager*\nVersion: %s\nUptime: %s\nCluster: %s (%d peers)\n*AlertManager Bot*\nVersion: %s\nUptime: %s",
+		s.VersionInfo.Version,
+		durafmt.Parse(time.Since(s.Uptime)),
+		s.Cluster.Status,
+		len(s.Cluster.Peers),
+		revision,
+		durafmt.Parse(time.Since(startTime)),
+	)
+}
@@ -0,0 +1,69 @@
+package telegram
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/metalmatze/alertmanager-bot/pkg/alertmanager"
+	"github.com/prometheus/alertmanager/template"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+func TestResponseTemplates(t *testing.T) {
+	template.DefaultFuncs["since"] = func(t time.Time) string { return "1m" }
+	template.DefaultFuncs["duration"] = func(start time.Time, end time.Time) string { return "5m" }
+
+	notEqual := false
+	silence := alertmanager.Silence{
+		ID: "abc",
+		Matchers: []alertmanager.Matcher{
+			{Name: "alertname", Value: "NodeDown"},
+			{Name: "instance", Value: "node-.*", IsRegex: true},
+			{Name: "env", Value: "dev", IsEqual: &notEqual},
+		},
+		StartsAt: time.Now().Add(-time.Minute),
+		EndsAt:   time.Now().Add(time.Hour),
+		Comment:  "<maintenance>",
+	}
+
+	b := &Bot{templatePaths: []string{"../../default.tmpl"}, revision: "v1"}
+	assert.Nil(t, b.ReloadTemplates())
+
+	out, mode, err := b.silencesMessage(silence, silence)
+	assert.Nil(t, err)
+	assert.Equal(t, telebot.ModeHTML, mode)
+	assert.Contains(t, out, "<b>NodeDown</b> 🔕")
+	assert.Contains(t, out, `instance=~&#34;node-.*&#34;`)
+	assert.Contains(t, out, `env!=&#34;dev&#34;`)
+	assert.Contains(t, out, "&lt;maintenance&gt;")
+	assert.Contains(t, out, "<b>Ends in:</b> 5m")
+
+	out, mode, err = b.statusMessage(alertmanager.StatusResponse{})
+	assert.Nil(t, err)
+	assert.Equal(t, telebot.ModeHTML, mode)
+	assert.Contains(t, out, "<b>AlertManager Bot</b>\nVersion: v1")
+
+	// Templates without the response templates fall back to Markdown
+	dir, err := ioutil.TempDir("", "templates")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "telegram.tmpl")
+	assert.Nil(t, ioutil.WriteFile(path, []byte(`{{ define "telegram.default" }}alerts{{ end }}`), 0644))
+
+	b = &Bot{templatePaths: []string{path}}
+	assert.Nil(t, b.ReloadTemplates())
+
+	out, mode, err = b.silencesMessage(silence)
+	assert.Nil(t, err)
+	assert.Equal(t, telebot.ModeMarkdown, mode)
+	assert.Equal(t, alertmanager.SilenceMessage(silence)+"\n", out)
+
+	_, mode, err = b.statusMessage(alertmanager.StatusResponse{})
+	assert.Nil(t, err)
+	assert.Equal(t, telebot.ModeMarkdown, mode)
+}
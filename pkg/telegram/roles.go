@@ -0,0 +1,206 @@
+package telegram
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+// Role is what a user is allowed to do with the bot
+type Role string
+
+// Roles ordered from the least to the most allowed, every role is allowed what the ones before are
+const (
+	RoleNone Role = ""
+	// RoleViewer can read alerts, silences and the bot's status
+	RoleViewer Role = "viewer"
+	// RoleResponder can also acknowledge, silence and mute alerts
+	RoleResponder Role = "responder"
+	// RoleAdmin can also manage chats, their configuration and the roles of other users
+	RoleAdmin Role = "admin"
+)
+
+var roleRanks = map[Role]int{
+	RoleNone:      0,
+	RoleViewer:    1,
+	RoleResponder: 2,
+	RoleAdmin:     3,
+}
+
+// Allows returns whether the role is allowed what the required role is
+func (r Role) Allows(required Role) bool {
+	return roleRanks[r] >= roleRanks[required]
+}
+
+// max returns the more allowed of both roles
+func (r Role) max(other Role) Role {
+	if other.Allows(r) {
+		return other
+	}
+	return r
+}
+
+// parseRole parses the name of a role a user can be granted
+func parseRole(name string) (Role, error) {
+	switch r := Role(strings.ToLower(name)); r {
+	case RoleViewer, RoleResponder, RoleAdmin:
+		return r, nil
+	}
+	return RoleNone, fmt.Errorf("unknown role %s, expected %s, %s or %s", name, RoleViewer, RoleResponder, RoleAdmin)
+}
+
+// UserRoles are the roles granted to a user
+type UserRoles struct {
+	// Role in all chats, empty for none
+	Role Role
+	// Chats are the roles in single chats by their ID
+	Chats map[int64]Role
+}
+
+// In returns the user's role in the chat, the more allowed of its role in all chats and in this chat
+func (u UserRoles) In(chatID int64) Role {
+	return u.Role.max(u.Chats[chatID])
+}
+
+// commandRoles are the roles required to run the commands, commands missing require admin
+var commandRoles = map[string]Role{
+	commandHelp:          RoleViewer,
+	commandStatus:        RoleViewer,
+	commandAlerts:        RoleViewer,
+	commandSilences:      RoleViewer,
+	commandSilence:       RoleViewer,
+	commandMutes:         RoleViewer,
+	commandSubscriptions: RoleViewer,
+	commandRoutes:        RoleViewer,
+
+	commandSilenceAdd: RoleResponder,
+	commandSilenceDel: RoleResponder,
+	commandMute:       RoleResponder,
+	commandMuteDel:    RoleResponder,
+}
+
+// globalCommands change more than the chat they're run in, they require the role in all chats
+var globalCommands = map[string]bool{
+	commandChats:           true,
+	commandRouteAdd:        true,
+	commandRouteDel:        true,
+	commandReloadTemplates: true,
+}
+
+// callbackRoles are the roles required to press the inline buttons, buttons missing require admin
+var callbackRoles = map[string]Role{
+	callbackAlertsPage: RoleViewer,
+	callbackSilence:    RoleResponder,
+	callbackAck:        RoleResponder,
+}
+
+// commandRole returns the role required to run the command the text starts with
+func commandRole(text string) Role {
+	if role, ok := commandRoles[commandName(text)]; ok {
+		return role
+	}
+	return RoleAdmin
+}
+
+// commandName returns the command the text starts with, without the bot's name
+func commandName(text string) string {
+	fields := strings.Fields(text)
+	if len(fields) == 0 {
+		return ""
+	}
+	return strings.SplitN(fields[0], "@", 2)[0]
+}
+
+// callbackRole returns the role required to press the buttons with the unique name
+func callbackRole(unique string) Role {
+	if role, ok := callbackRoles[unique]; ok {
+		return role
+	}
+	return RoleAdmin
+}
+
+// commandAllowed returns whether the sender's role allows running the message's command.
+// Global commands require the role in all chats, a role in the chat they're run in isn't enough.
+func (b *Bot) commandAllowed(message *telebot.Message) bool {
+	chatID := message.Chat.ID
+	if globalCommands[commandName(message.Text)] {
+		chatID = 0
+	}
+	return b.userRole(message.Sender.ID, chatID).Allows(commandRole(message.Text))
+}
+
+// checkRoleScope returns an error if the sender may not grant or revoke roles in the chat, 0 for all chats.
+// Admins in all chats may change any role, admins of a single chat only roles in that chat up to their own.
+func (b *Bot) checkRoleScope(senderID int, here, chatID int64, role Role) error {
+	if b.userRole(senderID, 0).Allows(RoleAdmin) {
+		return nil
+	}
+	if chatID != here {
+		return fmt.Errorf("only admins in all chats may change roles %s", rolesScope(chatID))
+	}
+	if !b.userRole(senderID, here).Allows(role) {
+		return fmt.Errorf("you can't grant the role %s, it's above your own", role)
+	}
+	return nil
+}
+
+// parseGrantCommand parses "<user_id> <role> [here|<chat_id>]" into the user, the role and the chat,
+// the chat is 0 to grant the role in all chats.
+func parseGrantCommand(text string, here int64) (int, Role, int64, error) {
+	fields := strings.Fields(text)
+	if len(fields) < 2 || len(fields) > 3 {
+		return 0, RoleNone, 0, fmt.Errorf("expected <user_id> <role> [here|<chat_id>]")
+	}
+
+	userID, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return 0, RoleNone, 0, fmt.Errorf("expected a user ID, got %s", fields[0])
+	}
+	role, err := parseRole(fields[1])
+	if err != nil {
+		return 0, RoleNone, 0, err
+	}
+
+	var chatID int64
+	if len(fields) == 3 {
+		if chatID, err = parseChatID(fields[2], here); err != nil {
+			return 0, RoleNone, 0, err
+		}
+	}
+	return userID, role, chatID, nil
+}
+
+// parseRevokeCommand parses "<user_id> [here|<chat_id>]" into the user and the chat,
+// the chat is 0 to revoke the role in all chats.
+func parseRevokeCommand(text string, here int64) (int, int64, error) {
+	fields := strings.Fields(text)
+	if len(fields) < 1 || len(fields) > 2 {
+		return 0, 0, fmt.Errorf("expected <user_id> [here|<chat_id>]")
+	}
+
+	userID, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return 0, 0, fmt.Errorf("expected a user ID, got %s", fields[0])
+	}
+
+	var chatID int64
+	if len(fields) == 2 {
+		if chatID, err = parseChatID(fields[1], here); err != nil {
+			return 0, 0, err
+		}
+	}
+	return userID, chatID, nil
+}
+
+func parseChatID(s string, here int64) (int64, error) {
+	if s == "here" {
+		return here, nil
+	}
+	chatID, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || chatID == 0 {
+		return 0, fmt.Errorf("expected here or a chat ID, got %s", s)
+	}
+	return chatID, nil
+}
@@ -0,0 +1,89 @@
+package telegram
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+func TestRoles(t *testing.T) {
+	assert.True(t, RoleAdmin.Allows(RoleResponder))
+	assert.True(t, RoleViewer.Allows(RoleViewer))
+	assert.False(t, RoleViewer.Allows(RoleResponder))
+	assert.False(t, RoleNone.Allows(RoleViewer))
+
+	assert.Equal(t, RoleViewer, commandRole("/alerts severity=critical"))
+	assert.Equal(t, RoleViewer, commandRole("/silences@alertbot"))
+	assert.Equal(t, RoleResponder, commandRole("/silence_add 2h alertname=\"NodeDown\""))
+	assert.Equal(t, RoleAdmin, commandRole("/start"))
+	assert.Equal(t, RoleAdmin, commandRole("/grant 1 admin"))
+	assert.Equal(t, RoleResponder, callbackRole(callbackAck))
+	assert.Equal(t, RoleAdmin, callbackRole("unknown"))
+
+	roles := UserRoles{Role: RoleViewer, Chats: map[int64]Role{-100: RoleResponder}}
+	assert.Equal(t, RoleResponder, roles.In(-100))
+	assert.Equal(t, RoleViewer, roles.In(-200))
+	assert.Equal(t, RoleNone, UserRoles{}.In(-100))
+}
+
+func TestParseGrantCommand(t *testing.T) {
+	userID, role, chatID, err := parseGrantCommand("123 responder", -100)
+	assert.Nil(t, err)
+	assert.Equal(t, 123, userID)
+	assert.Equal(t, RoleResponder, role)
+	assert.Equal(t, int64(0), chatID)
+
+	_, _, chatID, err = parseGrantCommand("123 Viewer here", -100)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(-100), chatID)
+
+	_, _, chatID, err = parseGrantCommand("123 admin -200", -100)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(-200), chatID)
+
+	for _, text := range []string{"", "123", "bob admin", "123 owner", "123 admin there"} {
+		_, _, _, err := parseGrantCommand(text, -100)
+		assert.NotNil(t, err, text)
+	}
+
+	userID, chatID, err = parseRevokeCommand("123 here", -100)
+	assert.Nil(t, err)
+	assert.Equal(t, 123, userID)
+	assert.Equal(t, int64(-100), chatID)
+}
+
+func TestGroupAdminAllowed(t *testing.T) {
+	b := &Bot{groupAdmins: true}
+
+	private := &telebot.Message{Text: "/start", Sender: &telebot.User{ID: 1}, Chat: &telebot.Chat{ID: 1, Type: telebot.ChatPrivate}}
+	assert.False(t, b.groupAdminAllowed(private))
+
+	grant := &telebot.Message{Text: "/grant 1 admin", Sender: &telebot.User{ID: 1}, Chat: &telebot.Chat{ID: -100, Type: telebot.ChatGroup}}
+	assert.False(t, b.groupAdminAllowed(grant))
+
+	b.groupAdmins = false
+	start := &telebot.Message{Text: "/start@alertbot", Sender: &telebot.User{ID: 1}, Chat: &telebot.Chat{ID: -100, Type: telebot.ChatGroup}}
+	assert.False(t, b.groupAdminAllowed(start))
+}
+
+func TestGroupAdminsCache(t *testing.T) {
+	c := newGroupAdminsCache(time.Minute)
+	now := time.Now()
+
+	_, ok := c.get(-100, now)
+	assert.False(t, ok)
+
+	c.set(-100, map[int]bool{1: true}, now)
+	admins, ok := c.get(-100, now.Add(30*time.Second))
+	assert.True(t, ok)
+	assert.True(t, admins[1])
+	assert.False(t, admins[2])
+
+	_, ok = c.get(-100, now.Add(time.Minute))
+	assert.False(t, ok)
+
+	c.set(-200, map[int]bool{2: true}, now.Add(time.Minute))
+	assert.Len(t, c.groups, 1)
+}
@@ -0,0 +1,47 @@
+package telegram
+
+// Route delivers the webhooks received on /webhook/{name} only to its chats
+type Route struct {
+	Name    string
+	ChatIDs []int64
+}
+
+// AddChat adds the chat to the route unless it's part of it already
+func (r *Route) AddChat(id int64) {
+	if r.HasChat(id) {
+		return
+	}
+	r.ChatIDs = append(r.ChatIDs, id)
+}
+
+// RemoveChat removes the chat from the route and returns whether it was part of it
+func (r *Route) RemoveChat(id int64) bool {
+	for i, chatID := range r.ChatIDs {
+		if chatID == id {
+			r.ChatIDs = append(r.ChatIDs[:i], r.ChatIDs[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// HasChat returns whether the chat is part of the route
+func (r Route) HasChat(id int64) bool {
+	for _, chatID := range r.ChatIDs {
+		if chatID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// routeChats returns the chats of the route among all chats
+func routeChats(route Route, chatInfos []ChatInfo) []ChatInfo {
+	var routed []ChatInfo
+	for _, chatInfo := range chatInfos {
+		if route.HasChat(chatInfo.Chat.ID) {
+			routed = append(routed, chatInfo)
+		}
+	}
+	return routed
+}
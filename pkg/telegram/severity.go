@@ -0,0 +1,63 @@
+package telegram
+
+import (
+	"strings"
+
+	"github.com/prometheus/alertmanager/template"
+)
+
+// Severities with their own templates, telegram.critical, telegram.warning and telegram.info
+const (
+	severityCritical = "critical"
+	severityWarning  = "warning"
+	severityInfo     = "info"
+)
+
+// severities in the order their alerts are sent
+var severities = []string{severityCritical, severityWarning, severityInfo, ""}
+
+// alertSeverity returns the alert's known severity, empty for any other
+func alertSeverity(alert template.Alert) string {
+	severity := strings.ToLower(alert.Labels["severity"])
+	for _, s := range severities {
+		if s == severity {
+			return s
+		}
+	}
+	return ""
+}
+
+// severityGroup are the alerts of a severity
+type severityGroup struct {
+	Severity string
+	Alerts   template.Alerts
+}
+
+// groupBySeverity groups the alerts by their severity, most severe first
+func groupBySeverity(alerts template.Alerts) []severityGroup {
+	bySeverity := make(map[string]template.Alerts)
+	for _, alert := range alerts {
+		s := alertSeverity(alert)
+		bySeverity[s] = append(bySeverity[s], alert)
+	}
+
+	var groups []severityGroup
+	for _, s := range severities {
+		if len(bySeverity[s]) > 0 {
+			groups = append(groups, severityGroup{Severity: s, Alerts: bySeverity[s]})
+		}
+	}
+	return groups
+}
+
+// severityTemplate returns the name of the template to render alerts of the severity with.
+// Chats using the default template get telegram.<severity> if it's defined, chats that chose a template keep it.
+func (b *Bot) severityTemplate(chatTemplate, severity string) string {
+	if chatTemplate != "" || severity == "" {
+		return chatTemplate
+	}
+	if !b.templateDefined(templateName(severity)) {
+		return ""
+	}
+	return severity
+}
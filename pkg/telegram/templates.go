@@ -0,0 +1,157 @@
+package telegram
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	tmpltext "text/template"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/alertmanager/template"
+)
+
+// TemplateNameRegexp matches valid names of templates a chat can choose
+var TemplateNameRegexp = regexp.MustCompile(`^\w+$`)
+
+// templateName returns the name of the telegram template, telegram.default if name is empty
+func templateName(name string) string {
+	if name == "" {
+		name = "default"
+	}
+	return "telegram." + name
+}
+
+// executeTemplate renders the data with the telegram template of the given name
+func (b *Bot) executeTemplate(name string, data template.Data) (string, error) {
+	return executeNamed(b.currentTemplates(), templateName(name), data)
+}
+
+// templateDefined returns whether the current templates define the template of the given full name, e.g. telegram.critical
+func (b *Bot) templateDefined(name string) bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.templateNames[name]
+}
+
+// definedTemplates returns the names of all templates defined in the files matching the paths.
+// Like template.FromGlobs it allows paths not matching any file yet.
+func definedTemplates(paths ...string) (map[string]bool, error) {
+	t := tmpltext.New("").Funcs(tmpltext.FuncMap(template.DefaultFuncs))
+	for _, p := range paths {
+		matches, err := filepath.Glob(p)
+		if err != nil {
+			return nil, err
+		}
+		if len(matches) == 0 {
+			continue
+		}
+		if t, err = t.ParseGlob(p); err != nil {
+			return nil, err
+		}
+	}
+
+	names := make(map[string]bool)
+	for _, d := range t.Templates() {
+		names[d.Name()] = true
+	}
+	return names, nil
+}
+
+// executeNamed renders the data with the template of the given name
+func executeNamed(t *template.Template, name string, data interface{}) (string, error) {
+	return t.ExecuteHTMLString(`{{ template "`+name+`" . }}`, data)
+}
+
+// ReloadTemplates parses the template paths again and swaps the new templates in.
+// If parsing fails the old templates are kept and the error is returned.
+func (b *Bot) ReloadTemplates() error {
+	b.mu.RLock()
+	paths := b.templatePaths
+	externalURL := b.alertmanager
+	b.mu.RUnlock()
+
+	if len(paths) == 0 {
+		return errors.New("no template paths configured")
+	}
+
+	t, err := template.FromGlobs(paths...)
+	if err != nil {
+		return err
+	}
+	t.ExternalURL = externalURL
+	names, err := definedTemplates(paths...)
+	if err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	b.templates = t
+	b.templateNames = names
+	b.mu.Unlock()
+
+	return nil
+}
+
+// watchTemplates reloads the templates whenever one of the template files changed
+func (b *Bot) watchTemplates(ctx context.Context) error {
+	if b.templateReloadInterval <= 0 {
+		<-ctx.Done()
+		return nil
+	}
+
+	ticker := time.NewTicker(b.templateReloadInterval)
+	defer ticker.Stop()
+
+	b.mu.RLock()
+	last := templatesState(b.templatePaths)
+	b.mu.RUnlock()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		b.mu.RLock()
+		state := templatesState(b.templatePaths)
+		b.mu.RUnlock()
+		if state == last {
+			continue
+		}
+		last = state
+
+		if err := b.ReloadTemplates(); err != nil {
+			level.Error(b.logger).Log("msg", "failed to reload changed templates, keeping the old ones", "err", err)
+			continue
+		}
+		level.Info(b.logger).Log("msg", "reloaded changed templates")
+	}
+}
+
+// templatesState describes the name, size and modification time of all template files
+// to detect when any of them changed, was added or removed.
+func templatesState(paths []string) string {
+	var files []string
+	for _, p := range paths {
+		matches, err := filepath.Glob(p)
+		if err != nil {
+			continue
+		}
+		for _, m := range matches {
+			info, err := os.Stat(m)
+			if err != nil {
+				continue
+			}
+			files = append(files, fmt.Sprintf("%s:%d:%d", m, info.Size(), info.ModTime().UnixNano()))
+		}
+	}
+	sort.Strings(files)
+	return strings.Join(files, "\n")
+}
@@ -0,0 +1,118 @@
+package telegram
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/alertmanager/template"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReloadTemplates(t *testing.T) {
+	dir, err := ioutil.TempDir("", "templates")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "telegram.tmpl")
+	assert.Nil(t, ioutil.WriteFile(path, []byte(`{{ define "telegram.default" }}old{{ end }}`), 0644))
+
+	b := &Bot{templatePaths: []string{filepath.Join(dir, "*.tmpl")}}
+	assert.Nil(t, b.ReloadTemplates())
+	state := templatesState(b.templatePaths)
+
+	out, err := b.currentTemplates().ExecuteHTMLString(`{{ template "telegram.default" . }}`, template.Data{})
+	assert.Nil(t, err)
+	assert.Equal(t, "old", out)
+
+	assert.Nil(t, ioutil.WriteFile(path, []byte(`{{ define "telegram.default" }}{{ broken }}`), 0644))
+	assert.NotEqual(t, state, templatesState(b.templatePaths))
+	assert.NotNil(t, b.ReloadTemplates())
+
+	out, err = b.currentTemplates().ExecuteHTMLString(`{{ template "telegram.default" . }}`, template.Data{})
+	assert.Nil(t, err)
+	assert.Equal(t, "old", out)
+
+	assert.Nil(t, ioutil.WriteFile(path, []byte(`{{ define "telegram.default" }}new{{ end }}`), 0644))
+	assert.Nil(t, b.ReloadTemplates())
+
+	out, err = b.currentTemplates().ExecuteHTMLString(`{{ template "telegram.default" . }}`, template.Data{})
+	assert.Nil(t, err)
+	assert.Equal(t, "new", out)
+}
+
+func TestTemplateName(t *testing.T) {
+	assert.Equal(t, "telegram.default", templateName(""))
+	assert.Equal(t, "telegram.compact", templateName("compact"))
+	assert.True(t, TemplateNameRegexp.MatchString("detailed"))
+	assert.False(t, TemplateNameRegexp.MatchString(`default" . }}{{ template "x`))
+}
+
+func TestDefaultTemplates(t *testing.T) {
+	template.DefaultFuncs["since"] = func(t time.Time) string { return "1m" }
+	template.DefaultFuncs["duration"] = func(start time.Time, end time.Time) string { return "5m" }
+
+	b := &Bot{templatePaths: []string{"../../default.tmpl"}}
+	assert.Nil(t, b.ReloadTemplates())
+
+	data := template.Data{
+		Status: "firing",
+		Alerts: template.Alerts{{
+			Status:      "firing",
+			Labels:      template.KV{"alertname": "NodeDown", "severity": "critical"},
+			Annotations: template.KV{"message": "Node is down", "summary": "Node down", "runbook_url": "https://runbooks/node"},
+			StartsAt:    time.Now(),
+		}},
+	}
+
+	for _, name := range []string{"", "compact", "detailed"} {
+		out, err := b.executeTemplate(name, data)
+		assert.Nil(t, err, name)
+		assert.Contains(t, out, "NodeDown", name)
+	}
+
+	out, err := b.executeTemplate("detailed", data)
+	assert.Nil(t, err)
+	assert.Contains(t, out, "https://runbooks/node")
+
+	_, err = b.executeTemplate("missing", data)
+	assert.NotNil(t, err)
+}
+
+func TestDefinedTemplates(t *testing.T) {
+	names, err := definedTemplates("../../default.tmpl", "/nonexistent/*.tmpl")
+	assert.Nil(t, err)
+	assert.True(t, names["telegram.default"])
+	assert.True(t, names["telegram.critical"])
+	assert.False(t, names["telegram.warning"])
+}
+
+func TestSeverityTemplate(t *testing.T) {
+	b := &Bot{templatePaths: []string{"../../default.tmpl"}}
+	assert.Nil(t, b.ReloadTemplates())
+
+	assert.Equal(t, "critical", b.severityTemplate("", severityCritical))
+	assert.Equal(t, "info", b.severityTemplate("", severityInfo))
+	assert.Equal(t, "", b.severityTemplate("", severityWarning))
+	assert.Equal(t, "", b.severityTemplate("", ""))
+	assert.Equal(t, "compact", b.severityTemplate("compact", severityCritical))
+}
+
+func TestGroupBySeverity(t *testing.T) {
+	alerts := template.Alerts{
+		{Labels: template.KV{"alertname": "Info", "severity": "info"}},
+		{Labels: template.KV{"alertname": "Other"}},
+		{Labels: template.KV{"alertname": "Critical", "severity": "Critical"}},
+		{Labels: template.KV{"alertname": "Page", "severity": "page"}},
+	}
+
+	groups := groupBySeverity(alerts)
+	assert.Equal(t, 3, len(groups))
+	assert.Equal(t, severityCritical, groups[0].Severity)
+	assert.Equal(t, "Critical", groups[0].Alerts[0].Labels["alertname"])
+	assert.Equal(t, severityInfo, groups[1].Severity)
+	assert.Equal(t, "", groups[2].Severity)
+	assert.Equal(t, 2, len(groups[2].Alerts))
+}
@@ -0,0 +1,180 @@
+package telegram
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+const (
+	// SecretTokenHeader is the header Telegram sends the webhook's secret token in
+	SecretTokenHeader = "X-Telegram-Bot-Api-Secret-Token"
+
+	// setWebhookRetryInterval is how long to wait before trying to set the webhook again
+	setWebhookRetryInterval = 30 * time.Second
+)
+
+// updatesWebhook is a telebot.Poller receiving the updates Telegram pushes to the bot's webhook,
+// instead of long polling for them. It's served as http.Handler on the bot's webserver.
+type updatesWebhook struct {
+	logger      log.Logger
+	client      *http.Client
+	url         string
+	secretToken string
+	certificate string
+	updates     chan telebot.Update
+	// polling is 1 while Poll passes the updates on to the bot
+	polling int32
+}
+
+func newUpdatesWebhook(publicURL, secretToken, certificate string) *updatesWebhook {
+	return &updatesWebhook{
+		logger:      log.NewNopLogger(),
+		client:      &http.Client{Timeout: 30 * time.Second},
+		url:         publicURL,
+		secretToken: secretToken,
+		certificate: certificate,
+		updates:     make(chan telebot.Update),
+	}
+}
+
+// Poll sets the webhook and passes the updates Telegram pushes on to the bot until it stops.
+// Like telebot's pollers it closes stop once it returns, the bot waits for that to stop.
+func (w *updatesWebhook) Poll(b *telebot.Bot, dest chan telebot.Update, stop chan struct{}) {
+	defer close(stop)
+
+	for {
+		err := w.setWebhook(b.URL, b.Token)
+		if err == nil {
+			break
+		}
+		level.Warn(w.logger).Log("msg", "failed to set telegram webhook, retrying", "err", err, "retry", setWebhookRetryInterval)
+
+		select {
+		case <-time.After(setWebhookRetryInterval):
+		case <-stop:
+			return
+		}
+	}
+	level.Info(w.logger).Log("msg", "set telegram webhook", "url", w.url)
+
+	atomic.StoreInt32(&w.polling, 1)
+	defer atomic.StoreInt32(&w.polling, 0)
+
+	for {
+		select {
+		case update := <-w.updates:
+			select {
+			case dest <- update:
+			case <-stop:
+				return
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+// ServeHTTP receives an update pushed by Telegram
+func (w *updatesWebhook) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		rw.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if w.secretToken != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get(SecretTokenHeader)), []byte(w.secretToken)) != 1 {
+		level.Warn(w.logger).Log("msg", "rejected telegram update with invalid secret token", "remote", r.RemoteAddr)
+		rw.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if atomic.LoadInt32(&w.polling) == 0 {
+		// the bot isn't running or isn't the leader, Telegram sends the update again
+		rw.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	var update telebot.Update
+	if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+		http.Error(rw, fmt.Sprintf("failed to decode update: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	select {
+	case w.updates <- update:
+	case <-r.Context().Done():
+		// Telegram sends the update again if it isn't acknowledged
+		rw.WriteHeader(http.StatusServiceUnavailable)
+	}
+}
+
+// setWebhook registers the webhook with Telegram, uploading the certificate if there is one
+func (w *updatesWebhook) setWebhook(apiURL, token string) error {
+	body := &bytes.Buffer{}
+	mw := multipart.NewWriter(body)
+
+	if err := mw.WriteField("url", w.url); err != nil {
+		return err
+	}
+	if w.secretToken != "" {
+		if err := mw.WriteField("secret_token", w.secretToken); err != nil {
+			return err
+		}
+	}
+	if w.certificate != "" {
+		if err := writeFile(mw, "certificate", w.certificate); err != nil {
+			return fmt.Errorf("failed to read certificate: %v", err)
+		}
+	}
+	if err := mw.Close(); err != nil {
+		return err
+	}
+
+	resp, err := w.client.Post(fmt.Sprintf("%s/bot%s/setWebhook", apiURL, token), mw.FormDataContentType(), body)
+	if err != nil {
+		// the request's URL contains the bot's token, it must not end up in the logs
+		if uerr, ok := err.(*url.Error); ok {
+			return uerr.Err
+		}
+		return err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		OK          bool   `json:"ok"`
+		Description string `json:"description"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("failed to decode response with status %s: %v", resp.Status, err)
+	}
+	if !result.OK {
+		return fmt.Errorf("telegram: %s (%d)", result.Description, resp.StatusCode)
+	}
+	return nil
+}
+
+func writeFile(mw *multipart.Writer, field, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	part, err := mw.CreateFormFile(field, filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(part, f)
+	return err
+}
@@ -0,0 +1,137 @@
+package telegram
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+func TestUpdatesWebhookServeHTTP(t *testing.T) {
+	w := newUpdatesWebhook("https://bot.example.com/telegram", "secret", "")
+
+	received := make(chan telebot.Update, 1)
+	go func() { received <- <-w.updates }()
+
+	req := httptest.NewRequest(http.MethodPost, "/telegram", strings.NewReader(`{"update_id":1}`))
+	rec := httptest.NewRecorder()
+	w.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	req = httptest.NewRequest(http.MethodGet, "/telegram", nil)
+	rec = httptest.NewRecorder()
+	w.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+
+	req = httptest.NewRequest(http.MethodPost, "/telegram", strings.NewReader(`{"update_id":1}`))
+	req.Header.Set(SecretTokenHeader, "secret")
+	rec = httptest.NewRecorder()
+	w.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+
+	w.polling = 1
+
+	req = httptest.NewRequest(http.MethodPost, "/telegram", strings.NewReader(`{"update_id":`))
+	req.Header.Set(SecretTokenHeader, "secret")
+	rec = httptest.NewRecorder()
+	w.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	req = httptest.NewRequest(http.MethodPost, "/telegram", strings.NewReader(`{"update_id":1,"message":{"message_id":2,"text":"/status"}}`))
+	req.Header.Set(SecretTokenHeader, "secret")
+	rec = httptest.NewRecorder()
+	w.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	update := <-received
+	assert.Equal(t, 1, update.ID)
+	assert.Equal(t, "/status", update.Message.Text)
+}
+
+func TestUpdatesWebhookSetWebhook(t *testing.T) {
+	dir, err := ioutil.TempDir("", "alertmanager-bot")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	certificate := filepath.Join(dir, "cert.pem")
+	assert.NoError(t, ioutil.WriteFile(certificate, []byte("-----BEGIN CERTIFICATE-----"), 0644))
+
+	var form map[string][]string
+	var cert string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/bot123:abc/setWebhook", r.URL.Path)
+		assert.NoError(t, r.ParseMultipartForm(1<<20))
+		form = r.MultipartForm.Value
+
+		f, _, err := r.FormFile("certificate")
+		assert.NoError(t, err)
+		content, _ := ioutil.ReadAll(f)
+		cert = string(content)
+
+		w.Write([]byte(`{"ok":true,"result":true,"description":"Webhook was set"}`))
+	}))
+	defer srv.Close()
+
+	w := newUpdatesWebhook("https://bot.example.com/telegram", "secret", certificate)
+	assert.NoError(t, w.setWebhook(srv.URL, "123:abc"))
+	assert.Equal(t, []string{"https://bot.example.com/telegram"}, form["url"])
+	assert.Equal(t, []string{"secret"}, form["secret_token"])
+	assert.Equal(t, "-----BEGIN CERTIFICATE-----", cert)
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"ok":false,"error_code":400,"description":"Bad Request: bad webhook: HTTPS url must be provided for webhook"}`))
+	}))
+	defer failing.Close()
+
+	w = newUpdatesWebhook("http://bot.example.com/telegram", "", "")
+	err = w.setWebhook(failing.URL, "123:abc")
+	assert.EqualError(t, err, "telegram: Bad Request: bad webhook: HTTPS url must be provided for webhook (400)")
+
+	w = newUpdatesWebhook("https://bot.example.com/telegram", "", filepath.Join(dir, "missing.pem"))
+	assert.Error(t, w.setWebhook(srv.URL, "123:abc"))
+}
+
+func TestUpdatesWebhookStartStop(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/bot123:abc/getMe":
+			w.Write([]byte(`{"ok":true,"result":{"id":1,"is_bot":true,"first_name":"bot","username":"bot"}}`))
+		case "/bot123:abc/setWebhook":
+			w.Write([]byte(`{"ok":true,"result":true,"description":"Webhook was set"}`))
+		default:
+			w.Write([]byte(`{"ok":true,"result":true}`))
+		}
+	}))
+	defer srv.Close()
+
+	w := newUpdatesWebhook("https://bot.example.com/telegram", "", "")
+	bot, err := telebot.NewBot(telebot.Settings{URL: srv.URL, Token: "123:abc", Poller: w})
+	assert.NoError(t, err)
+
+	stopped := make(chan struct{})
+	go func() {
+		bot.Start()
+		close(stopped)
+	}()
+
+	for atomic.LoadInt32(&w.polling) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	bot.Stop()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("bot didn't stop")
+	}
+	assert.Equal(t, int32(0), atomic.LoadInt32(&w.polling))
+}